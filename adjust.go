@@ -0,0 +1,228 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Curve maps an intensity from 0 (black) to 1 (white) to a new intensity, for tonal adjustments.
+// The result may be outside of 0..1, and is clamped when applied.
+type Curve func(v float64) float64
+
+// adjustmentUsage has the usage message for each tonal adjustment
+var adjustmentUsage = map[string]string{
+	"invert":     "invert",
+	"brightness": "brightness -100..100",
+	"contrast":   "contrast -100..100",
+	"levels":     "levels BLACK WHITE [GAMMA], where BLACK and WHITE are 0-F",
+	"posterize":  "posterize 2..16",
+}
+
+// ParseAdjustment returns the curve for the tonal adjustment with the given name and arguments,
+// like "posterize" and "4", and a description of it
+func ParseAdjustment(name string, args []string) (Curve, string, error) {
+	usage, ok := adjustmentUsage[name]
+	if !ok {
+		return nil, "", errors.New("unknown adjustment: " + name)
+	}
+	usageError := errors.New("usage: " + usage)
+	// percent returns the first argument as a number from -100 to 100
+	percent := func() (float64, error) {
+		if len(args) != 1 {
+			return 0, usageError
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(args[0], "%"))
+		if err != nil || n < -100 || n > 100 {
+			return 0, usageError
+		}
+		return float64(n) / 100, nil
+	}
+	switch name {
+	case "invert":
+		if len(args) != 0 {
+			return nil, "", usageError
+		}
+		return func(v float64) float64 { return 1 - v }, "Inverted", nil
+	case "brightness":
+		amount, err := percent()
+		if err != nil {
+			return nil, "", err
+		}
+		return func(v float64) float64 { return v + amount }, "Adjusted the brightness of", nil
+	case "contrast":
+		amount, err := percent()
+		if err != nil {
+			return nil, "", err
+		}
+		// Less contrast moves all values towards the middle gray, and full contrast makes everything black or white
+		factor := 1 + amount
+		if amount > 0 {
+			factor = 1 / math.Max(1-amount, 0.001)
+		}
+		return func(v float64) float64 { return (v-0.5)*factor + 0.5 }, "Adjusted the contrast of", nil
+	case "levels":
+		if len(args) < 2 || len(args) > 3 {
+			return nil, "", usageError
+		}
+		black, err := ParseValue(args[0])
+		if err != nil || black == transparent {
+			return nil, "", usageError
+		}
+		white, err := ParseValue(args[1])
+		if err != nil || white == transparent || white <= black {
+			return nil, "", errors.New("the white point must be lighter than the black point")
+		}
+		gamma := 1.0
+		if len(args) == 3 {
+			if gamma, err = strconv.ParseFloat(args[2], 64); err != nil || gamma < 0.1 || gamma > 10 {
+				return nil, "", errors.New("the gamma must be from 0.1 to 10")
+			}
+		}
+		b, w := float64(black)/15, float64(white)/15
+		return func(v float64) float64 {
+			v = (v - b) / (w - b)
+			if v <= 0 {
+				return 0
+			}
+			return math.Pow(v, 1/gamma)
+		}, "Adjusted the levels of", nil
+	case "posterize":
+		if len(args) != 1 {
+			return nil, "", usageError
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 2 || n > 16 {
+			return nil, "", usageError
+		}
+		steps := float64(n - 1)
+		return func(v float64) float64 { return math.Round(v*steps) / steps }, "Posterized", nil
+	}
+	return nil, "", usageError
+}
+
+// clamp01 returns the given value, limited to the range 0..1
+func clamp01(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
+
+// Adjust returns a copy of the pixels, with the curve applied to every pixel that is not transparent
+func (p *Pixels) Adjust(curve Curve) *Pixels {
+	p2 := p.Copy()
+	for i, v := range p2.values {
+		if v != transparent {
+			p2.values[i] = byte(math.Round(clamp01(curve(float64(v)/15)) * 15))
+		}
+	}
+	return p2
+}
+
+// AdjustImage returns a copy of the image in full color, with the curve applied to the red, green and blue
+// channels of every pixel. The alpha channel is kept as it is.
+func AdjustImage(m image.Image, curve Curve) *image.NRGBA {
+	b := m.Bounds()
+	m2 := image.NewNRGBA(b)
+	channel := func(c uint8) uint8 {
+		return uint8(math.Round(clamp01(curve(float64(c)/255)) * 255))
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			m2.SetNRGBA(x, y, color.NRGBA{channel(c.R), channel(c.G), channel(c.B), c.A})
+		}
+	}
+	return m2
+}
+
+// adjustCommand returns a command that applies the tonal adjustment with the given name
+// to the selection, or to the whole image
+func adjustCommand(name string) command {
+	return func(e *Editor, args []string) (string, error) {
+		curve, description, err := ParseAdjustment(name, args)
+		if err != nil {
+			return "", err
+		}
+		return description + " the " + e.where(), e.Transform(func(p *Pixels) (*Pixels, error) {
+			return p.Adjust(curve), nil
+		})
+	}
+}
+
+// previewCommands are the commands that are shown on the image while they are being typed in,
+// before return is pressed. They must only change the pixel values, not the size of the image.
+var previewCommands = map[string]bool{
+	"badge":      true,
+	"brightness": true,
+	"contrast":   true,
+	"filter":     true,
+	"gradient":   true,
+	"invert":     true,
+	"levels":     true,
+	"posterize":  true,
+	"replace":    true,
+	"text":       true,
+	"variant":    true,
+}
+
+// Preview will show the result of the given command line on the image, if it is a command
+// that can be previewed, like "posterize 4". Any earlier preview is removed first.
+// Returns true if the image should be redrawn.
+func (e *Editor) Preview(line string) bool {
+	redraw := e.previewLines != nil
+	e.EndPreview()
+	fields := strings.Fields(line)
+	if len(fields) == 0 || !previewCommands[strings.ToLower(fields[0])] {
+		return redraw
+	}
+	e.previewLines, e.wasChanged = e.CopyLines(), e.changed
+	if _, err := commands[strings.ToLower(fields[0])](e, fields[1:]); err != nil {
+		// Not a complete command yet
+		e.EndPreview()
+		return redraw
+	}
+	return true
+}
+
+// EndPreview will remove the preview from the image, if there is one
+func (e *Editor) EndPreview() {
+	if e.previewLines == nil {
+		return
+	}
+	e.saveAllLines()
+	e.lines, e.changed = e.previewLines, e.wasChanged
+	e.previewLines, e.previewImage = nil, nil
+	e.redraw = true
+}
+
+// adjustSubcommand applies a tonal adjustment to an image file, like "adjust in.png out.png contrast 20".
+// PNG images are adjusted and written in full color, while .ico and .fav files are written in 16 levels of gray.
+func adjustSubcommand(args []string) (string, error) {
+	if len(args) < 3 {
+		return "", errors.New("usage: adjust INPUT OUTPUT invert|brightness|contrast|levels|posterize [ARGUMENTS]")
+	}
+	input, output, name := args[0], args[1], strings.ToLower(args[2])
+	curve, description, err := ParseAdjustment(name, args[3:])
+	if err != nil {
+		return "", err
+	}
+	description = fmt.Sprintf("%s %s and wrote it to %s", description, input, output)
+	if strings.HasSuffix(input, ".png") && strings.HasSuffix(output, ".png") {
+		m, err := readImage(input)
+		if err != nil {
+			return "", err
+		}
+		return description, writePNG(output, AdjustImage(m, curve))
+	}
+	entries, err := ReadEntries(input)
+	if err != nil {
+		return "", err
+	}
+	for i, entry := range entries {
+		entries[i] = entry.Adjust(curve)
+	}
+	return description, WriteEntries(output, entries)
+}
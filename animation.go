@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+)
+
+// pngSignature is the first 8 bytes of every PNG image
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// grayPalette returns the 16 gray levels that are used when saving images, followed by a transparent color
+func grayPalette() color.Palette {
+	palette := make(color.Palette, 0, 17)
+	for v := 0; v < 16; v++ {
+		intensity := uint8(v*16 + 15) // from 0..15 to 15..255, just like WriteFavicon
+		palette = append(palette, color.NRGBA{intensity, intensity, intensity, 0xff})
+	}
+	return append(palette, color.NRGBA{0, 0, 0, 0})
+}
+
+// Paletted converts the pixels to a paletted image, using the palette from grayPalette
+func (p *Pixels) Paletted() *image.Paletted {
+	m := image.NewPaletted(p.Bounds(), grayPalette())
+	for y := 0; y < p.h; y++ {
+		for x := 0; x < p.w; x++ {
+			// the transparent value is also the index of the transparent color
+			m.SetColorIndex(x, y, p.At(x, y))
+		}
+	}
+	return m
+}
+
+// ExportAnimation will save all the frames as an animated GIF, if the filename ends with ".gif",
+// or as an animated PNG if not
+func (e *Editor) ExportAnimation(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if strings.HasSuffix(filename, ".gif") {
+		return e.EncodeGIF(f)
+	}
+	return e.EncodeAPNG(f)
+}
+
+// EncodeGIF will write all the frames as an animated GIF that loops forever
+func (e *Editor) EncodeGIF(w io.Writer) error {
+	anim := &gif.GIF{}
+	for i, f := range e.frames.list {
+		anim.Image = append(anim.Image, e.FrameComposite(i).Paletted())
+		anim.Delay = append(anim.Delay, f.delay/10) // in 100ths of a second
+		anim.Disposal = append(anim.Disposal, gif.DisposalBackground)
+	}
+	return gif.EncodeAll(w, anim)
+}
+
+// pngChunk is a chunk in a PNG file, like IHDR or IDAT
+type pngChunk struct {
+	kind string
+	data []byte
+}
+
+// readPNGChunks returns the chunks in the given PNG file
+func readPNGChunks(data []byte) ([]pngChunk, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errors.New("not a PNG image")
+	}
+	var chunks []pngChunk
+	for data = data[len(pngSignature):]; len(data) >= 12; {
+		length := int(binary.BigEndian.Uint32(data))
+		if len(data) < 12+length {
+			return nil, errors.New("truncated PNG chunk")
+		}
+		chunks = append(chunks, pngChunk{string(data[4:8]), data[8 : 8+length]})
+		data = data[12+length:]
+	}
+	return chunks, nil
+}
+
+// writePNGChunk will write a PNG chunk, including the length and the checksum
+func writePNGChunk(w io.Writer, kind string, data []byte) error {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(len(data)))
+	buf.WriteString(kind)
+	buf.Write(data)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(buf.Bytes()[4:]))
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// EncodeAPNG will write all the frames as an animated PNG that loops forever.
+// Each frame is encoded with image/png, and the image data is then moved into APNG frame chunks.
+// Programs that do not support APNG will show the first frame.
+func (e *Editor) EncodeAPNG(w io.Writer) error {
+	if _, err := w.Write(pngSignature); err != nil {
+		return err
+	}
+	seq := uint32(0) // the sequence number for fcTL and fdAT chunks
+	for i, f := range e.frames.list {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, e.FrameComposite(i).Paletted()); err != nil {
+			return err
+		}
+		chunks, err := readPNGChunks(buf.Bytes())
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			// Write the header, the animation control chunk and the palette, before the first image data
+			for _, chunk := range chunks {
+				if chunk.kind == "IHDR" {
+					if err := writePNGChunk(w, chunk.kind, chunk.data); err != nil {
+						return err
+					}
+					var actl bytes.Buffer
+					binary.Write(&actl, binary.BigEndian, []uint32{uint32(e.frames.Len()), 0}) // number of frames, loop forever
+					if err := writePNGChunk(w, "acTL", actl.Bytes()); err != nil {
+						return err
+					}
+				} else if chunk.kind == "PLTE" || chunk.kind == "tRNS" {
+					if err := writePNGChunk(w, chunk.kind, chunk.data); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		var fctl bytes.Buffer
+		binary.Write(&fctl, binary.BigEndian, []uint32{seq, uint32(e.width), uint32(e.height), 0, 0})
+		binary.Write(&fctl, binary.BigEndian, []uint16{uint16(f.delay), 1000})
+		fctl.Write([]byte{1, 0}) // clear to transparent before the next frame, and replace the pixels
+		if err := writePNGChunk(w, "fcTL", fctl.Bytes()); err != nil {
+			return err
+		}
+		seq++
+		for _, chunk := range chunks {
+			if chunk.kind != "IDAT" {
+				continue
+			}
+			if i == 0 {
+				err = writePNGChunk(w, "IDAT", chunk.data)
+			} else {
+				var fdat bytes.Buffer
+				binary.Write(&fdat, binary.BigEndian, seq)
+				fdat.Write(chunk.data)
+				err = writePNGChunk(w, "fdAT", fdat.Bytes())
+				seq++
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return writePNGChunk(w, "IEND", nil)
+}
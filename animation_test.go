@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"testing"
+)
+
+// animatedEditor returns an editor with three frames that differ, where the second frame has another delay
+func animatedEditor(t *testing.T) *Editor {
+	e := newTestEditor(5, 3, 7)
+	paint := func(x, y int, v byte) {
+		e.GoToPixel(x, y)
+		e.brush = v
+		e.PaintBrush()
+	}
+	paint(0, 0, 0)
+	for i, cmd := range []string{"frame duplicate", "frame delay 250", "frame add"} {
+		if _, err := e.RunCommand(cmd); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+		paint(i+1, i%3, 15)
+	}
+	return e
+}
+
+// checkedPNGChunks returns the chunks in the given PNG file, and fails the test if a checksum is wrong
+func checkedPNGChunks(t *testing.T, data []byte) []pngChunk {
+	t.Helper()
+	if !bytes.HasPrefix(data, pngSignature) {
+		t.Fatal("expected a PNG signature")
+	}
+	var chunks []pngChunk
+	for data = data[len(pngSignature):]; len(data) > 0; {
+		if len(data) < 12 {
+			t.Fatalf("expected a chunk of at least 12 bytes, got %d bytes", len(data))
+		}
+		length := int(binary.BigEndian.Uint32(data))
+		if len(data) < 12+length {
+			t.Fatalf("expected %d more bytes, got %d", 12+length, len(data))
+		}
+		kind := string(data[4:8])
+		if crc, expected := binary.BigEndian.Uint32(data[8+length:]), crc32.ChecksumIEEE(data[4:8+length]); crc != expected {
+			t.Errorf("%s: expected the checksum %08x, got %08x", kind, expected, crc)
+		}
+		chunks = append(chunks, pngChunk{kind, data[8 : 8+length]})
+		data = data[12+length:]
+	}
+	return chunks
+}
+
+func TestEncodeAPNG(t *testing.T) {
+	e := animatedEditor(t)
+	var buf bytes.Buffer
+	if err := e.EncodeAPNG(&buf); err != nil {
+		t.Fatal(err)
+	}
+	chunks := checkedPNGChunks(t, buf.Bytes())
+	if len(chunks) < 5 || chunks[0].kind != "IHDR" || chunks[1].kind != "acTL" || chunks[2].kind != "PLTE" {
+		t.Fatalf("expected IHDR, acTL and PLTE first, got %d chunks", len(chunks))
+	}
+	if frames := binary.BigEndian.Uint32(chunks[1].data); frames != 3 {
+		t.Errorf("expected 3 frames in acTL, got %d", frames)
+	}
+	if chunks[len(chunks)-1].kind != "IEND" {
+		t.Errorf("expected IEND last, got %s", chunks[len(chunks)-1].kind)
+	}
+
+	// Collect the image data for each frame, and check the order and the sequence numbers
+	var (
+		header []pngChunk // IHDR, PLTE and tRNS, for making a PNG image of a single frame
+		frames [][]byte   // the image data of each frame
+		seq    uint32
+	)
+	for i, chunk := range chunks[:len(chunks)-1] {
+		switch chunk.kind {
+		case "IHDR", "PLTE", "tRNS":
+			if len(frames) > 0 {
+				t.Errorf("chunk %d: expected %s before the first frame", i, chunk.kind)
+			}
+			header = append(header, chunk)
+		case "acTL":
+		case "fcTL":
+			if got := binary.BigEndian.Uint32(chunk.data); got != seq {
+				t.Errorf("chunk %d: expected the sequence number %d, got %d", i, seq, got)
+			}
+			seq++
+			f := e.frames.list[len(frames)]
+			w, h := binary.BigEndian.Uint32(chunk.data[4:]), binary.BigEndian.Uint32(chunk.data[8:])
+			delay := binary.BigEndian.Uint16(chunk.data[20:])
+			if int(w) != e.width || int(h) != e.height || int(delay) != f.delay {
+				t.Errorf("frame %d: expected %dx%d and %d ms, got %dx%d and %d ms", len(frames), e.width, e.height, f.delay, w, h, delay)
+			}
+			frames = append(frames, nil)
+		case "IDAT":
+			if len(frames) != 1 {
+				t.Errorf("chunk %d: expected IDAT only in the first frame", i)
+			}
+			frames[len(frames)-1] = append(frames[len(frames)-1], chunk.data...)
+		case "fdAT":
+			if len(frames) < 2 {
+				t.Errorf("chunk %d: expected fdAT only after the first frame", i)
+			}
+			if got := binary.BigEndian.Uint32(chunk.data); got != seq {
+				t.Errorf("chunk %d: expected the sequence number %d, got %d", i, seq, got)
+			}
+			seq++
+			frames[len(frames)-1] = append(frames[len(frames)-1], chunk.data[4:]...)
+		default:
+			t.Errorf("chunk %d: unexpected %s", i, chunk.kind)
+		}
+	}
+	if len(frames) != 3 {
+		t.Fatalf("expected 3 frames, got %d", len(frames))
+	}
+
+	// Programs that do not support APNG should show the first frame
+	m, err := png.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFrame(t, 0, e, m)
+
+	// Every frame should decode as a PNG image on its own
+	for i, data := range frames {
+		var single bytes.Buffer
+		single.Write(pngSignature)
+		for _, chunk := range header {
+			writePNGChunk(&single, chunk.kind, chunk.data)
+		}
+		writePNGChunk(&single, "IDAT", data)
+		writePNGChunk(&single, "IEND", nil)
+		m, err := png.Decode(&single)
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		checkFrame(t, i, e, m)
+	}
+}
+
+// checkFrame compares a decoded image with the given frame
+func checkFrame(t *testing.T, i int, e *Editor, m image.Image) {
+	t.Helper()
+	p, ok := m.(*image.Paletted)
+	if !ok {
+		t.Fatalf("frame %d: expected a paletted image, got %T", i, m)
+	}
+	if expected := e.FrameComposite(i).Paletted(); !bytes.Equal(p.Pix, expected.Pix) {
+		t.Errorf("frame %d: expected the pixels %v, got %v", i, expected.Pix, p.Pix)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// badgeLabels are the notification badges that are written by "badge all": a dot, the counts from 1 to 9 and 9+
+var badgeLabels = []string{"", "1", "2", "3", "4", "5", "6", "7", "8", "9", "9+"}
+
+// badgeCorners are the corners a notification badge can be placed in, as 0 or 1 for the left or right, and the top or bottom
+var badgeCorners = map[string]image.Point{"nw": {0, 0}, "ne": {1, 0}, "sw": {0, 1}, "se": {1, 1}}
+
+// notificationBadge is a dot, or a count like "3" or "9+", in a corner of an icon, for showing unread notifications
+type notificationBadge struct {
+	label      string // "" for a dot
+	corner     image.Point
+	c          color.NRGBA
+	outline    color.NRGBA
+	hasOutline bool
+	all        bool // write every badge from badgeLabels?
+}
+
+// badgeUsage is the usage message for the badge command, without the command name
+const badgeUsage = "dot|COUNT|all [corner nw|ne|sw|se] [color COLOR] [outline COLOR|none], where COLOR is 0-F or RRGGBB"
+
+// parseBadge parses the badge and its options, like "3 corner ne color ff0000 outline ffffff".
+// Counts above 9 are shown as 9+. The default is a red badge in the upper right corner, without an outline.
+func parseBadge(args []string) (*notificationBadge, error) {
+	usage := errors.New("usage: badge " + badgeUsage)
+	if len(args) == 0 || len(args)%2 != 1 {
+		return nil, usage
+	}
+	b := &notificationBadge{corner: badgeCorners["ne"], c: color.NRGBA{0xee, 0x22, 0x22, 0xff}}
+	switch label := strings.ToLower(args[0]); label {
+	case "dot":
+	case "all":
+		b.all = true
+	case "9+":
+		b.label = label
+	default:
+		n, err := strconv.Atoi(label)
+		if err != nil || n < 1 {
+			return nil, usage
+		}
+		b.label = strconv.Itoa(n)
+		if n > 9 {
+			b.label = "9+"
+		}
+	}
+	for i := 1; i < len(args); i += 2 {
+		var err error
+		switch strings.ToLower(args[i]) {
+		case "corner":
+			corner, ok := badgeCorners[strings.ToLower(args[i+1])]
+			if !ok {
+				return nil, errors.New("the corner must be nw, ne, sw or se")
+			}
+			b.corner = corner
+		case "color":
+			b.c, err = parseVariantColor(args[i+1])
+		case "outline":
+			b.hasOutline = strings.ToLower(args[i+1]) != "none"
+			if b.hasOutline {
+				b.outline, err = parseVariantColor(args[i+1])
+			}
+		default:
+			return nil, usage
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// overlay returns where the badge is drawn on a w x h icon, as overlayFill for the badge, overlayInk for the digits
+// and overlayEdge for the outline, and transparent elsewhere. A dot is a circle, and a count is drawn with the
+// largest bitmap font that fits in a badge that is half as tall as the icon, which is widened for 9+.
+func (b *notificationBadge) overlay(w, h int) *Pixels {
+	s := w
+	if h < s {
+		s = h
+	}
+	var (
+		t  *Pixels
+		d  = (s*3 + 4) / 8 // the height of the badge
+		bw = d             // the width of the badge
+	)
+	if b.label != "" {
+		d = s / 2
+		f := fonts[fontNames[0]]
+		for _, name := range fontNames {
+			if fonts[name].height <= d-2 {
+				f = fonts[name]
+			}
+		}
+		t = f.Render([]string{b.label}, 0, 0)
+		if d < t.Height()+2 {
+			d = t.Height() + 2
+		}
+		bw = d
+		if pad := (d - t.Height()) / 2; t.Width()+2*pad > bw {
+			bw = t.Width() + 2*pad
+		}
+	}
+	if d < 3 {
+		d, bw = 3, 3
+	}
+	// The badge is a circle, or a pill shape with round ends if it is wider than it is tall
+	mask := NewPixels(bw, d, transparent)
+	r := float64(d) / 2
+	for y := 0; y < d; y++ {
+		for x := 0; x < bw; x++ {
+			cx := math.Max(r, math.Min(float64(bw)-r, float64(x)+0.5))
+			if math.Hypot(float64(x)+0.5-cx, float64(y)+0.5-r) <= r {
+				mask.Set(x, y, overlayFill)
+			}
+		}
+	}
+	if t != nil {
+		mask = stampText(mask, t, image.Pt((bw-t.Width())/2, (d-t.Height())/2), overlayInk)
+	}
+	// Leave room for the outline, between the badge and the edges of the icon
+	margin := 0
+	if b.hasOutline {
+		margin = 1
+	}
+	at := image.Pt(margin+(w-bw-2*margin)*b.corner.X, margin+(h-d-2*margin)*b.corner.Y)
+	p := NewPixels(w, h, transparent)
+	p.Paste(mask, at)
+	if b.hasOutline {
+		p = p.Over(p.Outline(false, true, overlayEdge))
+	}
+	return p
+}
+
+// colors returns the colors of the marks in the overlay
+func (b *notificationBadge) colors() map[byte]color.NRGBA {
+	colors := map[byte]color.NRGBA{overlayFill: b.c, overlayInk: inkColor(b.c)}
+	if b.hasOutline {
+		colors[overlayEdge] = b.outline
+	}
+	return colors
+}
+
+// ApplyPixels returns a copy of the pixels with the badge, in 16 levels of gray
+func (b *notificationBadge) ApplyPixels(p *Pixels) *Pixels {
+	p2 := p.Copy()
+	drawOverlay(p2, b.overlay(p.w, p.h), b.colors())
+	return p2
+}
+
+// ApplyImage returns a copy of the image with the badge, in full color
+func (b *notificationBadge) ApplyImage(m image.Image) *image.NRGBA {
+	m2 := copyNRGBA(m)
+	drawOverlayImage(m2, b.overlay(m2.Bounds().Dx(), m2.Bounds().Dy()), b.colors())
+	return m2
+}
+
+// badgeName returns the part of the filename for the badge with the given label, like "dot", "3" or "9plus"
+func badgeName(label string) string {
+	if label == "" {
+		return "dot"
+	}
+	return strings.Replace(label, "+", "plus", 1)
+}
+
+// writeBadges writes the badge, or every badge if b.all is true, as both .png and .ico files named after the base icon,
+// like favicon-dot.png, favicon-3.ico and favicon-9plus.png. PNG images are drawn on in full color, and other images
+// in 16 levels of gray, for every entry. Returns the names of the files that were written.
+func writeBadges(b *notificationBadge, filename string, m image.Image, entries []*Pixels) ([]string, error) {
+	labels := []string{b.label}
+	if b.all {
+		labels = badgeLabels
+	}
+	var filenames []string
+	base := strings.TrimSuffix(filename, filepath.Ext(filename)) + "-"
+	for _, label := range labels {
+		nb := *b
+		nb.label = label
+		name := base + badgeName(label)
+		var err error
+		if m != nil {
+			err = writePNG(name+".png", nb.ApplyImage(m))
+			if err == nil {
+				// .ico files are written in 16 levels of gray
+				err = WriteEntries(name+".ico", []*Pixels{nb.ApplyPixels(ImagePixels(m))})
+			}
+		} else {
+			changed := make([]*Pixels, len(entries))
+			for i, entry := range entries {
+				changed[i] = nb.ApplyPixels(entry)
+			}
+			err = WriteEntries(name+".png", changed[:1])
+			if err == nil {
+				err = WriteEntries(name+".ico", changed)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		filenames = append(filenames, name+".png", name+".ico")
+	}
+	return filenames, nil
+}
+
+// badgeCommand draws a notification badge on the image or selection, like "badge 3 corner ne", or writes
+// every badge next to the image with "badge all". While the command is being typed in, the badge is shown on the image.
+func badgeCommand(e *Editor, args []string) (string, error) {
+	b, err := parseBadge(args)
+	if err != nil {
+		return "", err
+	}
+	if b.all && e.previewLines != nil {
+		b.label = "9+"
+	} else if b.all {
+		if e.filename == "" {
+			return "", errors.New("the image has no filename")
+		}
+		entries := append([]*Pixels{e.Composite()}, e.entries...)
+		filenames, err := writeBadges(b, e.filename, nil, entries)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Wrote %d files, from %s to %s", len(filenames), filenames[0], filenames[len(filenames)-1]), nil
+	}
+	return "Added a badge to the " + e.where(), e.Transform(func(p *Pixels) (*Pixels, error) {
+		return b.ApplyPixels(p), nil
+	})
+}
+
+// badgeSubcommand writes an icon with a notification badge, or every badge with "all", as .png and .ico files,
+// like "badge favicon.png all outline ffffff"
+func badgeSubcommand(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", errors.New("usage: badge INPUT " + badgeUsage)
+	}
+	input := args[0]
+	b, err := parseBadge(args[1:])
+	if err != nil {
+		return "", err
+	}
+	var (
+		m       image.Image
+		entries []*Pixels
+	)
+	if strings.HasSuffix(input, ".png") {
+		m, err = readImage(input)
+	} else {
+		entries, err = ReadEntries(input)
+	}
+	if err != nil {
+		return "", err
+	}
+	filenames, err := writeBadges(b, input, m, entries)
+	if err != nil {
+		return "", err
+	}
+	return "Wrote " + strings.Join(filenames, ", "), nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"image"
+	"strconv"
+)
+
+// SetBrush will set the current brush value from a hex digit, 0 to F.
+// Returns false if the given string is not a hex digit.
+func (e *Editor) SetBrush(digit string) bool {
+	v, err := strconv.ParseUint(digit, 16, 8)
+	if err != nil || len(digit) != 1 {
+		return false
+	}
+	e.brush = byte(v)
+	return true
+}
+
+// DarkerBrush will make the current brush value one step darker, if possible
+func (e *Editor) DarkerBrush() {
+	if e.brush == transparent {
+		e.brush = 0
+	} else if e.brush > 0 {
+		e.brush--
+	}
+}
+
+// LighterBrush will make the current brush value one step lighter, if possible
+func (e *Editor) LighterBrush() {
+	if e.brush == transparent {
+		e.brush = 0
+	} else if e.brush < 15 {
+		e.brush++
+	}
+}
+
+// PickBrush will set the current brush value to the value of the pixel at the cursor (eyedropper).
+// Returns false if the cursor is not at a pixel.
+func (e *Editor) PickBrush() bool {
+	if !e.AtPixel() {
+		return false
+	}
+	e.brush = runeValue(e.Rune())
+	return true
+}
+
+// PaintBrush will paint the pixel at the cursor with the current brush value, or with the pattern if one is selected.
+// Pixels where the pattern is transparent are left as they are.
+func (e *Editor) PaintBrush() {
+	if e.pattern == nil {
+		e.Paint(valueRune(e.brush))
+		return
+	}
+	if !e.AtPixel() {
+		return
+	}
+	p := e.CursorPixel()
+	points := append([]image.Point{p}, e.symmetry.Mirror(p, e.width, e.height)...)
+	for _, mp := range points {
+		if v, ok := e.fillValue(mp.X, mp.Y); ok {
+			e.Set(mp.X*2, mp.Y, valueRune(v))
+		}
+	}
+	e.redraw = true
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"strings"
+)
+
+// anchors maps the names of the 9 anchor points to where the image is placed on a resized canvas,
+// as 0, 1 or 2 for the left, middle or right, and the top, middle or bottom
+var anchors = map[string]image.Point{
+	"nw": {0, 0}, "n": {1, 0}, "ne": {2, 0},
+	"w": {0, 1}, "c": {1, 1}, "e": {2, 1},
+	"sw": {0, 2}, "s": {1, 2}, "se": {2, 2},
+}
+
+// Resize returns the pixels placed on a new w x h canvas, at the given anchor point.
+// New pixels get the fill value, and pixels that end up outside of the canvas are cropped.
+func (p *Pixels) Resize(w, h int, anchor image.Point, fill byte) *Pixels {
+	p2 := NewPixels(w, h, fill)
+	p2.Paste(p, image.Pt((w-p.w)*anchor.X/2, (h-p.h)*anchor.Y/2))
+	return p2
+}
+
+// OpaqueBounds returns the smallest rectangle that contains all the pixels that are not transparent.
+// The rectangle is empty if all pixels are transparent.
+func (p *Pixels) OpaqueBounds() image.Rectangle {
+	var r image.Rectangle
+	for y := 0; y < p.h; y++ {
+		for x := 0; x < p.w; x++ {
+			if p.At(x, y) != transparent {
+				r = r.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	return r
+}
+
+// ParseSize parses an image size, like "32x32"
+func ParseSize(s string) (int, int, error) {
+	var w, h int
+	if _, err := fmt.Sscanf(strings.ToLower(s), "%dx%d", &w, &h); err != nil {
+		return 0, 0, errors.New("the size must be given as WIDTHxHEIGHT, like 32x32")
+	}
+	if w < 1 || h < 1 || w > maxImageSize || h > maxImageSize {
+		return 0, 0, fmt.Errorf("the size must be from 1x1 to %dx%d", maxImageSize, maxImageSize)
+	}
+	return w, h, nil
+}
+
+// allLayers calls the given function for every layer in every frame, except for the active layer,
+// which is in the textual representation
+func (e *Editor) allLayers(f func(l *Layer)) {
+	for _, l := range e.layers.stack {
+		if l.pixels != nil {
+			f(l)
+		}
+	}
+	for _, fr := range e.frames.list {
+		if fr.layers == nil {
+			continue
+		}
+		for _, l := range fr.layers.stack {
+			f(l)
+		}
+	}
+}
+
+// ResizeCanvas will apply the given function to every layer in every frame, which must return pixels
+// of the same size for every layer. The cursor is kept within the image and the selection is removed.
+func (e *Editor) ResizeCanvas(f func(p *Pixels) *Pixels) {
+	e.allLayers(func(l *Layer) {
+		l.pixels = f(l.pixels)
+	})
+	e.SetPixels(f(e.Pixels()))
+	e.marked = false
+	e.pixelScroll = 0
+	p := e.CursorPixel()
+	e.GoToPixel(p.X, p.Y)
+}
+
+// TrimBounds returns the smallest rectangle that contains all the pixels that are not transparent,
+// in any layer of any frame
+func (e *Editor) TrimBounds() image.Rectangle {
+	r := e.Pixels().OpaqueBounds()
+	e.allLayers(func(l *Layer) {
+		r = r.Union(l.pixels.OpaqueBounds())
+	})
+	return r
+}
+
+// canvasCommand resizes the canvas, like "canvas 32x32 c fill T", with the image placed at the given anchor point
+// and new pixels filled with the given value. The default is to keep the image centered and fill with transparency.
+// The fill value is given after "fill", since C and E are also anchors.
+func canvasCommand(e *Editor, args []string) (string, error) {
+	usage := errors.New("usage: canvas WIDTHxHEIGHT [nw|n|ne|w|c|e|sw|s|se] [fill 0-F|T]")
+	if len(args) < 1 || len(args) > 4 {
+		return "", usage
+	}
+	w, h, err := ParseSize(args[0])
+	if err != nil {
+		return "", err
+	}
+	var (
+		anchor = anchors["c"]
+		fill   = transparent
+	)
+	args = args[1:]
+	if len(args) > 0 && strings.ToLower(args[0]) != "fill" {
+		a, ok := anchors[strings.ToLower(args[0])]
+		if !ok {
+			return "", usage
+		}
+		anchor = a
+		args = args[1:]
+	}
+	if len(args) > 0 {
+		if len(args) != 2 || strings.ToLower(args[0]) != "fill" {
+			return "", usage
+		}
+		if fill, err = ParseValue(args[1]); err != nil {
+			return "", err
+		}
+	}
+	e.ResizeCanvas(func(p *Pixels) *Pixels {
+		return p.Resize(w, h, anchor, fill)
+	})
+	return fmt.Sprintf("Resized the canvas to %dx%d", w, h), nil
+}
+
+// cropCommand crops the image to the selection
+func cropCommand(e *Editor, args []string) (string, error) {
+	if len(args) != 0 {
+		return "", errors.New("usage: crop")
+	}
+	sel, ok := e.Selection()
+	if !ok {
+		return "", errors.New("select the pixels to keep first, with ctrl-b")
+	}
+	e.ResizeCanvas(func(p *Pixels) *Pixels {
+		return p.Sub(sel)
+	})
+	return fmt.Sprintf("Cropped the image to %dx%d", sel.Dx(), sel.Dy()), nil
+}
+
+// trimCommand removes the transparent borders around the image, in all layers and frames
+func trimCommand(e *Editor, args []string) (string, error) {
+	if len(args) != 0 {
+		return "", errors.New("usage: trim")
+	}
+	r := e.TrimBounds()
+	if r.Empty() {
+		return "", errors.New("the image is completely transparent")
+	}
+	if r == image.Rect(0, 0, e.width, e.height) {
+		return "There are no transparent borders to trim", nil
+	}
+	e.ResizeCanvas(func(p *Pixels) *Pixels {
+		return p.Sub(r)
+	})
+	return fmt.Sprintf("Trimmed the image to %dx%d", r.Dx(), r.Dy()), nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+func TestCanvasCommand(t *testing.T) {
+	for _, tc := range []struct {
+		cmd    string
+		w, h   int
+		at     image.Point // where the 2x2 image ends up
+		fill   byte
+		hasErr bool
+	}{
+		{"canvas 4x4", 4, 4, image.Pt(1, 1), transparent, false},
+		{"canvas 4x4 fill C", 4, 4, image.Pt(1, 1), 12, false},
+		{"canvas 4x4 c fill E", 4, 4, image.Pt(1, 1), 14, false},
+		{"canvas 4x4 e fill C", 4, 4, image.Pt(2, 1), 12, false},
+		{"canvas 4x3 nw fill 0", 4, 3, image.Pt(0, 0), 0, false},
+		{"canvas 4x4 C", 4, 4, image.Pt(1, 1), transparent, false},
+		{"canvas 4x4 3", 0, 0, image.Point{}, 0, true},
+		{"canvas 4x4 fill", 0, 0, image.Point{}, 0, true},
+		{"canvas 4x4 nw fill 3 3", 0, 0, image.Point{}, 0, true},
+		{"canvas 4x4 fill 3 nw", 0, 0, image.Point{}, 0, true},
+	} {
+		e := newTestEditor(2, 2, 7)
+		_, err := e.RunCommand(tc.cmd)
+		if tc.hasErr {
+			if err == nil {
+				t.Errorf("%s: expected an error", tc.cmd)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.cmd, err)
+			continue
+		}
+		expected := NewPixels(tc.w, tc.h, tc.fill)
+		expected.Paste(NewPixels(2, 2, 7), tc.at)
+		if got := pixelsText(e.Pixels()); got != pixelsText(expected) {
+			t.Errorf("%s: expected:\n%s\ngot:\n%s", tc.cmd, pixelsText(expected), got)
+		}
+	}
+}
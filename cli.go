@@ -0,0 +1,16 @@
+package main
+
+// subcommand is a function that runs without the TUI, with the arguments that follow the subcommand name.
+// Returns a message for stdout and an error type.
+type subcommand func(args []string) (string, error)
+
+// subcommands maps the names that can be given instead of a filename to the functions that run them
+var subcommands = map[string]subcommand{
+	"adjust":   adjustSubcommand,
+	"badge":    badgeSubcommand,
+	"generate": generateSubcommand,
+	"replace":  replaceSubcommand,
+	"scale":    scaleSubcommand,
+	"text":     textSubcommand,
+	"variant":  variantSubcommand,
+}
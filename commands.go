@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// command is a function that runs an editor command with the given arguments.
+// Returns a status message and an error type.
+type command func(e *Editor, args []string) (string, error)
+
+// commands maps command names, as typed in after pressing ctrl-o, to the functions that run them
+var commands = map[string]command{
+	"badge":      badgeCommand,
+	"brightness": adjustCommand("brightness"),
+	"bucket":     bucketCommand,
+	"canvas":     canvasCommand,
+	"contrast":   adjustCommand("contrast"),
+	"crop":       cropCommand,
+	"entry":      entryCommand,
+	"filter":     filterCommand,
+	"flip":       flipCommand,
+	"frame":      frameCommand,
+	"generate":   generateCommand,
+	"glow":       glowCommand,
+	"gradient":   gradientCommand,
+	"invert":     adjustCommand("invert"),
+	"layer":      layerCommand,
+	"levels":     adjustCommand("levels"),
+	"outline":    outlineCommand,
+	"pattern":    patternCommand,
+	"posterize":  adjustCommand("posterize"),
+	"rectangle":  rectangleCommand,
+	"replace":    replaceCommand,
+	"rotate":     rotateCommand,
+	"scale":      scaleCommand,
+	"shadow":     shadowCommand,
+	"shift":      shiftCommand,
+	"symmetry":   symmetryCommand,
+	"text":       textCommand,
+	"trace":      traceCommand,
+	"trim":       trimCommand,
+	"variant":    variantCommand,
+	"zoom":       zoomCommand,
+}
+
+// RunCommand will run the given command line, like "rotate 90".
+// Returns a status message and an error type.
+func (e *Editor) RunCommand(line string) (string, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", errors.New("no command given")
+	}
+	f, ok := commands[strings.ToLower(fields[0])]
+	if !ok {
+		return "", errors.New("unknown command: " + fields[0])
+	}
+	msg, err := f(e, fields[1:])
+	if err == nil {
+		e.redraw = true
+		e.redrawCursor = true
+	}
+	return msg, err
+}
+
+// where returns "selection" if there is a selection, and "image" if not
+func (e *Editor) where() string {
+	if _, ok := e.Selection(); ok {
+		return "selection"
+	}
+	return "image"
+}
+
+// flipCommand flips the image or selection horizontally ("flip h") or vertically ("flip v")
+func flipCommand(e *Editor, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", errors.New("usage: flip h|v")
+	}
+	switch strings.ToLower(args[0]) {
+	case "h", "horizontal":
+		return "Flipped the " + e.where() + " horizontally", e.Transform(func(p *Pixels) (*Pixels, error) {
+			return p.FlipHorizontal(), nil
+		})
+	case "v", "vertical":
+		return "Flipped the " + e.where() + " vertically", e.Transform(func(p *Pixels) (*Pixels, error) {
+			return p.FlipVertical(), nil
+		})
+	}
+	return "", errors.New("usage: flip h|v")
+}
+
+// rotateCommand rotates the image or selection clockwise, by 90, 180 or 270 degrees
+func rotateCommand(e *Editor, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", errors.New("usage: rotate 90|180|270")
+	}
+	degrees, err := strconv.Atoi(args[0])
+	if err != nil {
+		return "", errors.New("usage: rotate 90|180|270")
+	}
+	return "Rotated the " + e.where() + " by " + args[0] + " degrees", e.Transform(func(p *Pixels) (*Pixels, error) {
+		return p.Rotate(degrees)
+	})
+}
+
+// shiftCommand moves the image or selection in the given direction, with wrap-around
+func shiftCommand(e *Editor, args []string) (string, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return "", errors.New("usage: shift left|right|up|down [pixels]")
+	}
+	n := 1
+	if len(args) == 2 {
+		var err error
+		if n, err = strconv.Atoi(args[1]); err != nil {
+			return "", err
+		}
+	}
+	var dx, dy int
+	switch strings.ToLower(args[0]) {
+	case "l", "left":
+		dx = -n
+	case "r", "right":
+		dx = n
+	case "u", "up":
+		dy = -n
+	case "d", "down":
+		dy = n
+	default:
+		return "", errors.New("usage: shift left|right|up|down [pixels]")
+	}
+	return "Shifted the " + e.where() + " " + args[0], e.Transform(func(p *Pixels) (*Pixels, error) {
+		return p.Shift(dx, dy), nil
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"strings"
+)
+
+// GoToPixel will move the cursor to the given pixel, staying within the image
+func (e *Editor) GoToPixel(x, y int) {
+	if x >= e.width {
+		x = e.width - 1
+	}
+	if y >= e.height {
+		y = e.height - 1
+	}
+	if x < 0 {
+		x = 0
+	}
+	if y < 0 {
+		y = 0
+	}
+	e.pos.offset = 0
+	e.pos.sx = x * 2
+	e.pos.sy = y
+	e.redrawCursor = true
+}
+
+// MovePixel will move the cursor the given number of pixels to the right and down (or left and up, if negative).
+// If the cursor is not already at a pixel, it snaps to the closest pixel to the left.
+func (e *Editor) MovePixel(dx, dy int) {
+	p := e.CursorPixel()
+	e.GoToPixel(p.X+dx, p.Y+dy)
+}
+
+// AtPixel returns true if the cursor is at a pixel, and not at a blank between two pixels or outside of the image
+func (e *Editor) AtPixel() bool {
+	x, err := e.DataX()
+	return err == nil && x%2 == 0 && e.CursorPixel().In(image.Rect(0, 0, e.width, e.height))
+}
+
+// PixelStatusMessage returns a status message with the pixel coordinates, the value at the cursor,
+// the current mode and the image size
+func (e *Editor) PixelStatusMessage() string {
+	p := e.CursorPixel()
+	value := "-"
+	if e.AtPixel() {
+		v := runeValue(e.Rune())
+		value = valueName(v) + " " + string(valueRune(v))
+	}
+	msg := fmt.Sprintf("x %d y %d value %s mode %s size %dx%d", p.X, p.Y, value, e.mode, e.width, e.height)
+	if e.symmetry != symmetryOff {
+		msg += " symmetry " + e.symmetry.String()
+	}
+	if e.layers.Len() > 1 {
+		msg += fmt.Sprintf(" layer %d/%d %s", e.layers.active+1, e.layers.Len(), e.layers.Active().name)
+	}
+	if e.frames.Len() > 1 {
+		msg += fmt.Sprintf(" frame %d/%d", e.frames.active+1, e.frames.Len())
+	}
+	return msg
+}
+
+// PixelRow returns the pixels in the given row, in the textual representation
+func (e *Editor) PixelRow(y int) string {
+	var sb strings.Builder
+	for x := 0; x < e.width; x++ {
+		sb.WriteRune(valueRune(runeValue(e.Get(x*2, y))))
+		sb.WriteRune(' ')
+	}
+	return sb.String()
+}
+
+// ErasePixels will make the pixels in the given row black, from x0 and up to, but not including, x1,
+// just like typing blanks over them
+func (e *Editor) ErasePixels(x0, x1, y int) {
+	for x := x0; x < x1 && x < e.width; x++ {
+		e.Set(x*2, y, ' ')
+	}
+	e.redraw = true
+}
+
+// PastePixels will write the pixels in the given row, in the textual representation,
+// over the pixels from the cursor and to the right, as far as the image goes.
+// Returns the number of pixels that were written.
+func (e *Editor) PastePixels(row string) int {
+	p := e.CursorPixel()
+	runes := []rune(row)
+	n := 0
+	for x := p.X; x < e.width && n*2 < len(runes); x++ {
+		e.Set(x*2, p.Y, valueRune(runeValue(runes[n*2])))
+		n++
+	}
+	e.redraw = true
+	return n
+}
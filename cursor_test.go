@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestCutAndPastePixels(t *testing.T) {
+	e := newTestEditor(4, 3, 7)
+	e.GoToPixel(1, 0)
+	e.Paint(valueRune(15))
+	row := e.PixelRow(0)
+	e.ErasePixels(0, e.width, 0)
+	e.GoToPixel(2, 2)
+	if n := e.PastePixels(row); n != 2 {
+		t.Errorf("expected 2 pixels to be pasted, got %d", n)
+	}
+	e.GoToPixel(3, 1)
+	e.ErasePixels(3, e.width, 1)
+	expected := NewPixels(4, 3, 7)
+	for x := 0; x < 4; x++ {
+		expected.Set(x, 0, 0)
+	}
+	expected.Set(3, 1, 0)
+	expected.Set(3, 2, 15)
+	if got := pixelsText(e.Pixels()); got != pixelsText(expected) {
+		t.Errorf("expected:\n%s\ngot:\n%s", pixelsText(expected), got)
+	}
+	for y := 0; y < e.height; y++ {
+		if len(e.lines[y]) != e.width*2 {
+			t.Errorf("expected row %d to be %d runes, got %q", y, e.width*2, string(e.lines[y]))
+		}
+	}
+}
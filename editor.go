@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"image"
 	"io/ioutil"
 	"strings"
 	"unicode"
@@ -22,6 +23,19 @@ const (
 // Mode is a per-filetype mode, like for Markdown
 type Mode int
 
+// String returns a short name for the mode
+func (m Mode) String() string {
+	switch m {
+	case modeGray4:
+		return "gray4"
+	case modeRGB:
+		return "rgb"
+	case modeRGBA:
+		return "rgba"
+	}
+	return "blank"
+}
+
 // Editor represents the contents and editor settings, but not settings related to the viewport or scrolling
 type Editor struct {
 	lines        map[int][]rune       // the contents of the current document
@@ -36,6 +50,36 @@ type Editor struct {
 	gitColor     vt100.AttributeColor // git commit message color
 	wordWrapAt   int                  // set to 80 or 100 to trigger word wrap when typing to that column
 	mode         Mode                 // a filetype mode, like for git or markdown
+	width        int                  // the width of the image, in pixels
+	height       int                  // the height of the image, in pixels
+	marked       bool                 // is there a selection, from the mark to the cursor?
+	mark         image.Point          // the pixel where the selection starts
+	selectionBg  vt100.AttributeColor // background color for selected pixels
+	symmetry     Symmetry             // mirror painted pixels across one or more axes?
+	guideFg      vt100.AttributeColor // color for guides, like the symmetry axes
+	brush        byte                 // the current brush value, 0..15 or transparent
+	comparing    bool                 // show compareLines instead of the lines, for A/B comparisons?
+	compareLines map[int][]rune       // the lines of an earlier state, for A/B comparisons
+	layers       *Layers              // the layers of the image, where the pixels of the active one are in lines
+	frames       *Frames              // the frames of the animation, where the layers of the active one are in layers
+	onionSkin    bool                 // show the previous frame where the current frame is transparent?
+	filename     string               // the filename of the image, for exporting it with another extension
+	zoomLevel    int                  // the index of the zoom level in zoomLevels
+	pixelScroll  int                  // the first row of pixels that is shown, when the zoomed image is too tall
+	traceImage   image.Image          // a reference image to trace, or nil
+	trace        *Pixels              // the reference image, scaled down to the image size, or nil
+	tracing      bool                 // show the reference image behind transparent and empty pixels?
+	traceOpacity int                  // the opacity of the reference image, 0..100 percent
+	entries      []*Pixels            // the other images in the .ico file, which are saved after the one that is edited
+	previewLines map[int][]rune       // the lines from before a command was previewed, or nil
+	wasChanged   bool                 // the changed flag from before a command was previewed
+	previewImage *Pixels              // the whole image as it is previewed, for previews of all the layers, or nil
+	searching    bool                 // highlight the pixels with searchValue, and jump to them with ctrl-n?
+	searchValue  byte                 // the pixel value that is searched for with ctrl-f
+	pattern      *Pattern             // the pattern to fill with, instead of the brush value, or nil
+	patterns     map[string]*Pattern  // the patterns that have been captured from selections, by name
+	undoLines    map[int][]rune       // the lines from before the edit that is recorded for undo, saved as they are changed, or nil
+	undoAll      bool                 // were all the lines saved, because lines were moved around?
 }
 
 // NewEditor takes:
@@ -54,6 +98,15 @@ func NewEditor(fg, bg vt100.AttributeColor, textEditMode bool, scrollSpeed int,
 	// If the file is not to be highlighted, set word wrap to 99 (0 to disable)
 	e.wordWrapAt = 99
 	e.mode = mode
+	// New images are 16x16
+	e.width = 16
+	e.height = 16
+	e.selectionBg = vt100.BackgroundBlue
+	e.guideFg = vt100.DarkGray
+	e.layers = NewLayers("background")
+	e.frames = NewFrames()
+	e.zoomLevel = defaultZoom
+	e.traceOpacity = defaultTraceOpacity
 	return e
 }
 
@@ -81,6 +134,7 @@ func (e *Editor) Set(x, y int, r rune) {
 	if e.lines == nil {
 		e.lines = make(map[int][]rune)
 	}
+	e.saveLine(y)
 	_, ok := e.lines[y]
 	if !ok {
 		e.lines[y] = make([]rune, 0, x+1)
@@ -113,6 +167,66 @@ func (e *Editor) Get(x, y int) rune {
 	return runes[x]
 }
 
+// Pixels returns the pixel model of the image that is being edited.
+// Each pixel is represented by a rune followed by a blank, in the textual representation.
+func (e *Editor) Pixels() *Pixels {
+	p := NewPixels(e.width, e.height, transparent)
+	for y := 0; y < e.height; y++ {
+		for x := 0; x < e.width; x++ {
+			p.Set(x, y, runeValue(e.Get(x*2, y)))
+		}
+	}
+	return p
+}
+
+// SetPixels replaces the image that is being edited with the given pixels
+func (e *Editor) SetPixels(p *Pixels) {
+	for y := 0; y < p.Height(); y++ {
+		var sb strings.Builder
+		for x := 0; x < p.Width(); x++ {
+			sb.WriteRune(valueRune(p.At(x, y)))
+			sb.WriteRune(' ')
+		}
+		e.SetLine(y, sb.String())
+	}
+	// Remove the rows below the image, if it became smaller
+	for y := range e.lines {
+		if y >= p.Height() {
+			e.saveLine(y)
+			delete(e.lines, y)
+		}
+	}
+	e.width = p.Width()
+	e.height = p.Height()
+	e.changed = true
+}
+
+// CursorPixel returns the coordinates of the pixel at the cursor
+func (e *Editor) CursorPixel() image.Point {
+	x, _ := e.DataX()
+	return image.Pt(x/2, e.DataY())
+}
+
+// ToggleMark will start a selection at the pixel at the cursor, or remove the current selection
+func (e *Editor) ToggleMark() {
+	e.marked = !e.marked
+	e.mark = e.CursorPixel()
+	e.redraw = true
+}
+
+// Selection returns the rectangle of pixels from the mark to the cursor, both included.
+// Returns false if there is no selection.
+func (e *Editor) Selection() (image.Rectangle, bool) {
+	if !e.marked {
+		return image.Rectangle{}, false
+	}
+	cur := e.CursorPixel()
+	r := image.Rectangle{e.mark, cur}.Canon()
+	r.Max = r.Max.Add(image.Pt(1, 1))
+	r = r.Intersect(image.Rect(0, 0, e.width, e.height))
+	return r, !r.Empty()
+}
+
 // Changed will return true if the contents were changed since last time this function was called
 func (e *Editor) Changed() bool {
 	return e.changed
@@ -131,9 +245,14 @@ func (e *Editor) Line(n int) string {
 	return ""
 }
 
-// ScreenLine returns the screen contents of line number N, counting from 0
+// ScreenLine returns the screen contents of line number N, counting from 0.
+// When comparing with an earlier state, the line from that state is returned instead.
 func (e *Editor) ScreenLine(n int) string {
-	line, ok := e.lines[n]
+	lines := e.lines
+	if e.comparing {
+		lines = e.compareLines
+	}
+	line, ok := lines[n]
 	if ok {
 		var sb strings.Builder
 		for _, r := range line {
@@ -218,6 +337,7 @@ func (e *Editor) String() string {
 
 // Clear removes all data from the editor
 func (e *Editor) Clear() {
+	e.saveAllLines()
 	e.lines = make(map[int][]rune)
 	e.changed = true
 }
@@ -228,6 +348,8 @@ func (e *Editor) Load(c *vt100.Canvas, tty *vt100.TTY, filename string) (string,
 
 	var message string
 
+	e.filename = filename
+
 	var (
 		mode Mode
 		data []byte
@@ -243,6 +365,10 @@ func (e *Editor) Load(c *vt100.Canvas, tty *vt100.TTY, filename string) (string,
 			e.mode = mode
 			e.drawMode = true
 		}
+		if entries, err2 := ReadEntries(filename); err == nil && err2 == nil && len(entries) > 1 {
+			// Keep the other entries, so that they are not lost when saving
+			e.entries = entries[1:]
+		}
 	} else if strings.HasSuffix(filename, ".png") {
 		// Try to read the file
 		mode, data, message, err = ReadFavicon(filename, false, true)
@@ -250,6 +376,18 @@ func (e *Editor) Load(c *vt100.Canvas, tty *vt100.TTY, filename string) (string,
 			e.mode = mode
 			e.drawMode = true
 		}
+	} else if strings.HasSuffix(filename, ".fav") {
+		// Try to read the layered image
+		var frames *Frames
+		data, err = ioutil.ReadFile(filename)
+		if err == nil { // no error
+			frames, err = ReadLayeredImage(data)
+		}
+		if err == nil { // no error
+			e.mode = modeGray4
+			e.drawMode = true
+			data = e.UseFrames(frames)
+		}
 	} else {
 		// Any other file extension
 		data, err = ioutil.ReadFile(filename)
@@ -266,6 +404,16 @@ func (e *Editor) Load(c *vt100.Canvas, tty *vt100.TTY, filename string) (string,
 		return message, err
 	}
 
+	if e.drawMode && !strings.HasSuffix(filename, ".fav") {
+		// Also read the layers and frames, if they were saved next to the image
+		frames, err := ReadLayersFile(filename, string(data))
+		if err != nil {
+			message += " (could not read " + LayersFilename(filename) + ": " + err.Error() + ")"
+		} else if frames != nil {
+			data = e.UseFrames(frames)
+		}
+	}
+
 	datalines := bytes.Split(data, []byte{'\n'})
 	e.Clear()
 	for y, dataline := range datalines {
@@ -276,6 +424,10 @@ func (e *Editor) Load(c *vt100.Canvas, tty *vt100.TTY, filename string) (string,
 			counter++
 		}
 	}
+	if e.drawMode {
+		// Images can have any size up to 256x256
+		e.width, e.height = textSize(string(data))
+	}
 	// Mark the data as "not changed"
 	e.changed = false
 
@@ -287,6 +439,7 @@ func (e *Editor) Load(c *vt100.Canvas, tty *vt100.TTY, filename string) (string,
 // If it's anything else, it will just be blank.
 // Returns an editor mode and an error type.
 func (e *Editor) PrepareEmpty(c *vt100.Canvas, tty *vt100.TTY, filename string) (Mode, error) {
+	e.filename = filename
 	var (
 		mode Mode = modeBlank
 		data []byte
@@ -294,7 +447,7 @@ func (e *Editor) PrepareEmpty(c *vt100.Canvas, tty *vt100.TTY, filename string)
 	)
 
 	// Prepare the file
-	if strings.HasSuffix(filename, ".ico") {
+	if strings.HasSuffix(filename, ".ico") || strings.HasSuffix(filename, ".fav") {
 		// Create empty content
 		mode, data, _, err = ReadFavicon(filename, true, false)
 		if err == nil { // no error
@@ -342,7 +495,23 @@ func (e *Editor) Save(filename *string, asOther bool) error {
 		// Save the image as .png if this is a .ico file and asOther is true
 		// If asOther is false, save as the same filename
 		// TODO: Find a cleaner API
-		return WriteFavicon(e.mode, e.String(), *filename, asOther)
+		// Only the first frame is saved, if the image is animated
+		if err := WriteFavicon(e.mode, pixelsText(e.FrameComposite(0)), *filename, asOther, e.entries...); err != nil {
+			return err
+		}
+		if !e.Flat() && !asOther {
+			// Also keep the layers and frames, so that they can be edited later
+			return ioutil.WriteFile(LayersFilename(*filename), []byte(e.LayersText()), 0664)
+		}
+		return nil
+	}
+	if strings.HasSuffix(*filename, ".fav") {
+		if asOther {
+			// Export the flattened image as .ico
+			return WriteFavicon(e.mode, pixelsText(e.FrameComposite(0)), strings.TrimSuffix(*filename, ".fav")+".ico", false, e.entries...)
+		}
+		e.changed = false
+		return ioutil.WriteFile(*filename, []byte(e.LayersText()), 0664)
 	}
 	var data []byte
 	if stripTrailingSpaces {
@@ -370,6 +539,7 @@ func (e *Editor) TrimRight(n int) {
 	if _, ok := e.lines[n]; !ok {
 		return
 	}
+	e.saveLine(n)
 	lastIndex := len([]rune(e.lines[n])) - 1
 	// find the last non-space position
 	for x := lastIndex; x >= 0; x-- {
@@ -389,11 +559,16 @@ func (e *Editor) WriteLines(c *vt100.Canvas, fromline, toline, cx, cy int) error
 	if fromline >= toline {
 		return errors.New("fromline >= toline in WriteLines")
 	}
+	if e.drawMode {
+		// The pixels are drawn at the current zoom level, instead of as text
+		e.WritePixels(c, cx, cy)
+		return nil
+	}
 	numlines := toline - fromline
 	offset := fromline
 	for y := 0; y < numlines; y++ {
 		counter := 0
-		line := e.Line(y + offset)
+		line := e.ScreenLine(y + offset)
 		screenLine := strings.TrimRightFunc(line, unicode.IsSpace)
 		if len([]rune(screenLine)) >= w {
 			screenLine = screenLine[:w]
@@ -427,6 +602,7 @@ func (e *Editor) DeleteRestOfLine() {
 	if x >= len([]rune(e.lines[y])) {
 		return
 	}
+	e.saveLine(y)
 	e.lines[y] = e.lines[y][:x]
 	e.changed = true
 }
@@ -436,9 +612,11 @@ func (e *Editor) DeleteLine(n int) {
 	endOfDocument := n >= (e.Len() - 1)
 	if endOfDocument {
 		// Just delete this line
+		e.saveLine(n)
 		delete(e.lines, n)
 		return
 	}
+	e.saveAllLines()
 	// Shift all lines after y so that y is overwritten.
 	// Then delete the last item.
 	maxIndex := 0
@@ -481,6 +659,8 @@ func (e *Editor) Delete() {
 		e.changed = true
 		return
 	}
+	e.saveLine(y)
+	e.saveLine(y + 1)
 	x, err := e.DataX()
 	if err != nil || x >= len([]rune(e.lines[y]))-1 {
 		// on the last index, just use every element but x
@@ -536,6 +716,7 @@ func (e *Editor) MakeConsistent() {
 	// Check if the keys in the map are consistent
 	for i := 0; i < len(e.lines); i++ {
 		if _, found := e.lines[i]; !found {
+			e.saveLine(i)
 			e.lines[i] = make([]rune, 0)
 			e.changed = true
 		}
@@ -633,6 +814,8 @@ func (e *Editor) WrapAllLinesAt(n, maxOvershoot int) bool {
 		if len(first) > 0 && len(second) > 0 {
 
 			e.InsertLineBelowAt(i)
+			e.saveLine(i)
+			e.saveLine(i + 1)
 			e.lines[i] = first
 			e.lines[i+1] = second
 
@@ -650,6 +833,7 @@ func (e *Editor) WrapAllLinesAt(n, maxOvershoot int) bool {
 // InsertLineAbove will attempt to insert a new line above the current position
 func (e *Editor) InsertLineAbove() {
 	y := e.DataY()
+	e.saveAllLines()
 
 	// Create new set of lines
 	lines2 := make(map[int][]rune)
@@ -693,6 +877,8 @@ func (e *Editor) InsertLineBelow() {
 
 // InsertLineBelowAt will attempt to insert a new line below the given y position
 func (e *Editor) InsertLineBelowAt(y int) {
+	e.saveAllLines()
+
 	// Make sure no lines are nil
 	e.MakeConsistent()
 
@@ -746,9 +932,11 @@ func (e *Editor) Insert(r rune) {
 	x, _ := e.DataX()
 
 	y := e.DataY()
+	e.saveLine(y)
 
 	// If there are no lines, initialize and set the 0th rune to the given one
 	if e.lines == nil {
+		e.saveLine(0)
 		e.lines = make(map[int][]rune)
 		e.lines[0] = []rune{r}
 		return
@@ -788,6 +976,7 @@ func (e *Editor) CreateLineIfMissing(n int) {
 	}
 	_, ok := e.lines[n]
 	if !ok {
+		e.saveLine(n)
 		e.lines[n] = make([]rune, 0)
 		e.changed = true
 	}
@@ -810,6 +999,7 @@ func (e *Editor) WordCount() int {
 // SetLine will fill the given line index with the given string.
 // Any previous contents of that line is removed.
 func (e *Editor) SetLine(n int, s string) {
+	e.saveLine(n)
 	e.CreateLineIfMissing(n)
 	e.lines[n] = []rune{}
 	counter := 0
@@ -906,6 +1096,7 @@ func (e *Editor) nextLine(y int, c *vt100.Canvas, status *StatusBar) {
 // insertBelow will insert the given rune at the start of the line below,
 // starting a new line if required.
 func (e *Editor) insertBelow(y int, r rune) {
+	e.saveLine(y + 1)
 	if _, ok := e.lines[y+1]; !ok {
 		// If the next line does not exist, create one containing just "r"
 		e.lines[y+1] = []rune{r}
@@ -928,6 +1119,10 @@ func (e *Editor) InsertRune(c *vt100.Canvas, r rune) {
 		return
 	}
 
+	// Only this line and the next one are changed, except when a line is inserted below
+	e.saveLine(y)
+	e.saveLine(y + 1)
+
 	// --- Repaint, afterwards ---
 
 	e.changed = true
@@ -1322,6 +1517,11 @@ func (e *Editor) AfterLineScreenContentsPlusOne() bool {
 
 // WriteRune writes the current rune to the given canvas
 func (e *Editor) WriteRune(c *vt100.Canvas) {
+	if e.drawMode {
+		// The pixels are drawn at the current zoom level when the grid is redrawn
+		e.redraw = true
+		return
+	}
 	if c != nil {
 		c.WriteRune(uint(e.pos.sx), uint(e.pos.sy), e.fg, e.bg, e.Rune())
 	}
@@ -1459,6 +1659,9 @@ func (e *Editor) ColumnNumber() int {
 
 // StatusMessage returns a status message, intended for being displayed at the bottom
 func (e *Editor) StatusMessage() string {
+	if e.drawMode {
+		return e.PixelStatusMessage()
+	}
 	return fmt.Sprintf("line %d col %d rune %U words %d", e.LineNumber(), e.ColumnNumber(), e.Rune(), e.WordCount())
 }
 
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// bayer4 is a 4x4 ordered dither matrix, with thresholds from 0 to 15
+var bayer4 = [4][4]int{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+// bayerThreshold returns the ordered dither threshold for the pixel at x,y, from 0 to 1
+func bayerThreshold(x, y int) float64 {
+	return (float64(bayer4[mod(y, 4)][mod(x, 4)]) + 0.5) / 16
+}
+
+// Over returns a copy of the pixels, with the pixels from top drawn on top, where they are not transparent
+func (p *Pixels) Over(top *Pixels) *Pixels {
+	p2 := p.Copy()
+	for y := 0; y < p.h; y++ {
+		for x := 0; x < p.w; x++ {
+			if v := top.At(x, y); v != transparent {
+				p2.Set(x, y, v)
+			}
+		}
+	}
+	return p2
+}
+
+// neighbours returns the offsets to the 4 or 8 neighbours of a pixel
+func neighbours(connect8 bool) [][2]int {
+	if connect8 {
+		return [][2]int{{-1, -1}, {0, -1}, {1, -1}, {-1, 0}, {1, 0}, {-1, 1}, {0, 1}, {1, 1}}
+	}
+	return [][2]int{{0, -1}, {-1, 0}, {1, 0}, {0, 1}}
+}
+
+// Outline returns transparent pixels with an outline of the given value around the pixels that are not transparent.
+// An outer outline is drawn on the transparent pixels next to them, and an inner outline on their own edge pixels.
+// Pixels are next to each other if they share a side, or also a corner if connect8 is true.
+func (p *Pixels) Outline(inner, connect8 bool, v byte) *Pixels {
+	p2 := NewPixels(p.w, p.h, transparent)
+	for y := 0; y < p.h; y++ {
+		for x := 0; x < p.w; x++ {
+			if (p.At(x, y) == transparent) == inner {
+				continue
+			}
+			for _, n := range neighbours(connect8) {
+				// Pixels outside of the image are transparent, so that inner outlines are drawn along the edges
+				if (p.At(x+n[0], y+n[1]) == transparent) == inner {
+					p2.Set(x, y, v)
+					break
+				}
+			}
+		}
+	}
+	return p2
+}
+
+// Shadow returns transparent pixels with a shadow of the given value, which is the shape of the pixels
+// that are not transparent, moved dx pixels to the right and dy pixels down. The shadow is only drawn
+// where the pixels are transparent, so that it is behind them.
+func (p *Pixels) Shadow(dx, dy int, v byte) *Pixels {
+	p2 := NewPixels(p.w, p.h, transparent)
+	for y := 0; y < p.h; y++ {
+		for x := 0; x < p.w; x++ {
+			if p.At(x, y) == transparent && p.At(x-dx, y-dy) != transparent {
+				p2.Set(x, y, v)
+			}
+		}
+	}
+	return p2
+}
+
+// Glow returns transparent pixels with a soft glow of the given value, on the transparent pixels
+// that are within the radius of the pixels that are not transparent. The glow fades out towards the radius,
+// with ordered dithering, since each pixel is either fully transparent or not.
+func (p *Pixels) Glow(radius int, v byte) *Pixels {
+	p2 := NewPixels(p.w, p.h, transparent)
+	for y := 0; y < p.h; y++ {
+		for x := 0; x < p.w; x++ {
+			if p.At(x, y) != transparent {
+				continue
+			}
+			// Find the distance to the closest pixel that is not transparent
+			closest := math.Inf(1)
+			for ny := y - radius; ny <= y+radius; ny++ {
+				for nx := x - radius; nx <= x+radius; nx++ {
+					if p.At(nx, ny) != transparent {
+						closest = math.Min(closest, math.Hypot(float64(nx-x), float64(ny-y)))
+					}
+				}
+			}
+			if closest > float64(radius) {
+				continue
+			}
+			// Full strength next to the pixels, and fading out towards the radius
+			strength := 1 - (closest-1)/float64(radius)
+			if closest < 1.5 || strength > bayerThreshold(x, y) {
+				p2.Set(x, y, v)
+			}
+		}
+	}
+	return p2
+}
+
+// applyEffect will draw the effect, generated from the pixels that are being edited, onto the selection or the whole image,
+// or into a new layer with the given name, above the current one. Returns a status message.
+func (e *Editor) applyEffect(name string, layer bool, effect func(p *Pixels) *Pixels) (string, error) {
+	if layer {
+		p := effect(e.Pixels())
+		e.AddLayer(name)
+		e.SetPixels(p)
+		return "Added the " + name + " as a new layer", nil
+	}
+	return "Added the " + name + " to the " + e.where(), e.Transform(func(p *Pixels) (*Pixels, error) {
+		return p.Over(effect(p)), nil
+	})
+}
+
+// effectArgs removes "layer" from the end of the arguments, if it is there.
+// Returns the remaining arguments and true if "layer" was given.
+func effectArgs(args []string) ([]string, bool) {
+	if len(args) > 0 && strings.ToLower(args[len(args)-1]) == "layer" {
+		return args[:len(args)-1], true
+	}
+	return args, false
+}
+
+// outlineCommand adds an outline with the brush value around the pixels that are not transparent, like "outline outer 8 layer"
+func outlineCommand(e *Editor, args []string) (string, error) {
+	usage := errors.New("usage: outline [inner|outer] [4|8] [layer]")
+	args, layer := effectArgs(args)
+	v := e.brush
+	inner, connect8 := false, false
+	for _, arg := range args {
+		switch strings.ToLower(arg) {
+		case "inner":
+			inner = true
+		case "outer":
+			inner = false
+		case "8":
+			connect8 = true
+		case "4":
+			connect8 = false
+		default:
+			return "", usage
+		}
+	}
+	return e.applyEffect("outline", layer, func(p *Pixels) *Pixels {
+		return p.Outline(inner, connect8, v)
+	})
+}
+
+// shadowCommand adds a drop shadow with the brush value behind the pixels that are not transparent, like "shadow 1 1 layer".
+// The default offset is one pixel to the right and one pixel down.
+func shadowCommand(e *Editor, args []string) (string, error) {
+	usage := errors.New("usage: shadow [DX DY] [layer]")
+	args, layer := effectArgs(args)
+	v := e.brush
+	dx, dy := 1, 1
+	switch len(args) {
+	case 0:
+	case 2:
+		var err1, err2 error
+		dx, err1 = strconv.Atoi(args[0])
+		dy, err2 = strconv.Atoi(args[1])
+		if err1 != nil || err2 != nil {
+			return "", usage
+		}
+	default:
+		return "", usage
+	}
+	return e.applyEffect("shadow", layer, func(p *Pixels) *Pixels {
+		return p.Shadow(dx, dy, v)
+	})
+}
+
+// glowCommand adds a soft glow with the brush value around the pixels that are not transparent, like "glow 3 layer".
+// The default radius is 2 pixels.
+func glowCommand(e *Editor, args []string) (string, error) {
+	usage := errors.New("usage: glow [RADIUS] [layer], where RADIUS is 1..8")
+	args, layer := effectArgs(args)
+	v := e.brush
+	radius := 2
+	switch len(args) {
+	case 0:
+	case 1:
+		var err error
+		if radius, err = strconv.Atoi(args[0]); err != nil || radius < 1 || radius > 8 {
+			return "", usage
+		}
+	default:
+		return "", usage
+	}
+	return e.applyEffect("glow", layer, func(p *Pixels) *Pixels {
+		return p.Glow(radius, v)
+	})
+}
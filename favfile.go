@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// layersHeader is the first word in the textual representation of a layered image
+const layersHeader = "favicon-layers"
+
+// LayersFilename returns the filename of the layered image that belongs to the given .ico or .png file
+func LayersFilename(filename string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(filename, ".ico"), ".png") + ".fav"
+}
+
+// writeLayers will write one line per layer, followed by the rows of pixels in that layer.
+// All the layers must have their pixels set.
+func writeLayers(sb *strings.Builder, ls *Layers) {
+	for _, l := range ls.stack {
+		visibility := "visible"
+		if !l.visible {
+			visibility = "hidden"
+		}
+		fmt.Fprintf(sb, "layer %s %d %s\n", visibility, l.opacity, l.name)
+		sb.WriteString(pixelsText(l.pixels))
+		sb.WriteRune('\n')
+	}
+}
+
+// LayersText returns the textual representation of all the layers in all the frames,
+// for saving the layered image as a .fav file.
+// There is one header line, then one line per frame, if there are several frames,
+// and one line per layer, followed by the rows of pixels in that layer.
+func (e *Editor) LayersText() string {
+	var sb strings.Builder
+	if e.frames.Len() == 1 {
+		fmt.Fprintf(&sb, "%s %dx%d active %d\n", layersHeader, e.width, e.height, e.layers.active+1)
+		writeLayers(&sb, e.AllLayers())
+		return sb.String()
+	}
+	fmt.Fprintf(&sb, "%s %dx%d active %d frame %d\n", layersHeader, e.width, e.height, e.layers.active+1, e.frames.active+1)
+	for i, f := range e.frames.list {
+		ls := e.FrameLayers(i)
+		fmt.Fprintf(&sb, "frame %d active %d\n", f.delay, ls.active+1)
+		writeLayers(&sb, ls)
+	}
+	return sb.String()
+}
+
+// ReadLayeredImage parses the textual representation of a layered image, as written by LayersText.
+// All the layers in all the frames have their pixels set, including the ones in the active frame.
+func ReadLayeredImage(data []byte) (*Frames, error) {
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	var w, h, active, activeFrame int
+	header := strings.TrimPrefix(lines[0], layersHeader+" ")
+	if header == lines[0] {
+		return nil, errors.New("not a layered image")
+	}
+	if n, _ := fmt.Sscanf(header, "%dx%d active %d frame %d", &w, &h, &active, &activeFrame); n < 3 {
+		return nil, errors.New("invalid header: " + lines[0])
+	}
+	if w < 1 || h < 1 || w > maxImageSize || h > maxImageSize {
+		return nil, fmt.Errorf("the size must be from 1x1 to %dx%d", maxImageSize, maxImageSize)
+	}
+	fs := &Frames{}
+	if activeFrame > 0 {
+		fs.active = activeFrame - 1
+	}
+	var (
+		f   *Frame
+		l   *Layer
+		row int
+	)
+	for _, line := range lines[1:] {
+		if strings.HasPrefix(line, "frame ") {
+			var delay, frameActive int
+			if _, err := fmt.Sscanf(line, "frame %d active %d", &delay, &frameActive); err != nil {
+				return nil, errors.New("invalid frame: " + line)
+			}
+			f = &Frame{delay: delay, layers: &Layers{active: frameActive - 1}}
+			fs.list = append(fs.list, f)
+			l = nil
+			continue
+		}
+		if strings.HasPrefix(line, "layer ") {
+			if f == nil {
+				// The image is not animated
+				f = &Frame{delay: defaultFrameDelay, layers: &Layers{active: active - 1}}
+				fs.list = append(fs.list, f)
+			}
+			fields := strings.SplitN(line, " ", 4)
+			if len(fields) != 4 {
+				return nil, errors.New("invalid layer: " + line)
+			}
+			opacity, err := strconv.Atoi(fields[2])
+			if err != nil || opacity < 0 || opacity > 100 {
+				return nil, errors.New("invalid opacity: " + line)
+			}
+			l = &Layer{name: fields[3], visible: fields[1] != "hidden", opacity: opacity, pixels: NewPixels(w, h, transparent)}
+			f.layers.stack = append(f.layers.stack, l)
+			row = 0
+			continue
+		}
+		if l == nil {
+			return nil, errors.New("pixels before the first layer")
+		}
+		runes := []rune(line)
+		for x := 0; x < w; x++ {
+			if x*2 < len(runes) {
+				l.pixels.Set(x, row, runeValue(runes[x*2]))
+			} else {
+				// Trailing blanks may have been removed, and blanks are black
+				l.pixels.Set(x, row, 0)
+			}
+		}
+		row++
+	}
+	if fs.active < 0 || fs.active >= len(fs.list) {
+		return nil, errors.New("no such active frame")
+	}
+	for _, f := range fs.list {
+		if f.layers.active < 0 || f.layers.active >= f.layers.Len() {
+			return nil, errors.New("no such active layer")
+		}
+	}
+	return fs, nil
+}
+
+// ReadLayersFile reads the layered image that was saved next to the given .ico or .png file, if there is one.
+// The text is the image in the .ico or .png file. If the first frame of the layered image no longer looks like it,
+// the image has been changed by another program since then, and the layered image is not used.
+// Returns nil if there is no layered image that can be used.
+func ReadLayersFile(filename, text string) (*Frames, error) {
+	data, err := ioutil.ReadFile(LayersFilename(filename))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	frames, err := ReadLayeredImage(data)
+	if err != nil {
+		return nil, err
+	}
+	w, h := textSize(text)
+	first := frames.list[0].layers.stack[0].pixels
+	if first.w != w || first.h != h || pixelsText(frames.list[0].layers.flatten(w, h)) != pixelsText(textPixels(text, w, h)) {
+		return nil, nil
+	}
+	return frames, nil
+}
+
+// UseFrames makes the editor edit the given frames, which must have all their pixels set,
+// and returns the pixels of the active layer in the active frame, as text
+func (e *Editor) UseFrames(frames *Frames) []byte {
+	e.frames = frames
+	e.layers = frames.Active().layers
+	frames.Active().layers = nil
+	// The pixels of the active layer in the active frame are edited as text
+	data := []byte(pixelsText(e.layers.Active().pixels))
+	e.layers.Active().pixels = nil
+	return data
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// layeredEditor returns an editor with a layered image, with a hidden layer, a half transparent layer
+// and black pixels at the end of the rows, optionally with a second frame
+func layeredEditor(t *testing.T, animated bool) *Editor {
+	e := newTestEditor(6, 4, 7)
+	paint := func(x, y int, v byte) {
+		e.GoToPixel(x, y)
+		e.brush = v
+		e.PaintBrush()
+	}
+	paint(5, 0, 0)
+	paint(5, 3, 0)
+	cmds := []string{"layer add top layer", "layer opacity 40", "layer add hidden", "layer hide", "layer select 2"}
+	if animated {
+		cmds = append(cmds, "frame duplicate", "frame delay 250", "layer select 1")
+	}
+	for _, cmd := range cmds {
+		if _, err := e.RunCommand(cmd); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+	}
+	paint(1, 1, transparent)
+	paint(2, 2, 12)
+	return e
+}
+
+// checkLayers compares two layer stacks, including the pixels of every layer
+func checkLayers(t *testing.T, frame int, expected, got *Layers) {
+	t.Helper()
+	if got.Len() != expected.Len() || got.active != expected.active {
+		t.Fatalf("frame %d: expected %d layers with %d active, got %d layers with %d active", frame, expected.Len(), expected.active, got.Len(), got.active)
+	}
+	for i, l := range expected.stack {
+		g := got.stack[i]
+		if g.name != l.name || g.visible != l.visible || g.opacity != l.opacity {
+			t.Errorf("frame %d, layer %d: expected %s, got %s", frame, i, l, g)
+		}
+		if pixelsText(g.pixels) != pixelsText(l.pixels) {
+			t.Errorf("frame %d, layer %d: expected the pixels\n%s\ngot\n%s", frame, i, pixelsText(l.pixels), pixelsText(g.pixels))
+		}
+	}
+}
+
+func TestLayeredImageRoundTrip(t *testing.T) {
+	for _, animated := range []bool{false, true} {
+		e := layeredEditor(t, animated)
+		if e.layers.Len() != 3 || (e.frames.Len() == 2) != animated {
+			t.Fatalf("expected 3 layers, got %d layers and %d frames", e.layers.Len(), e.frames.Len())
+		}
+		text := e.LayersText()
+		frames, err := ReadLayeredImage([]byte(text))
+		if err != nil {
+			t.Fatalf("%v, for:\n%s", err, text)
+		}
+		if frames.Len() != e.frames.Len() || frames.active != e.frames.active {
+			t.Fatalf("expected %d frames with %d active, got %d frames with %d active", e.frames.Len(), e.frames.active, frames.Len(), frames.active)
+		}
+		for i, f := range frames.list {
+			if f.delay != e.frames.list[i].delay {
+				t.Errorf("frame %d: expected a delay of %d, got %d", i, e.frames.list[i].delay, f.delay)
+			}
+			checkLayers(t, i, e.FrameLayers(i), f.layers)
+		}
+	}
+}
+
+func TestLayeredImageTrailingBlanks(t *testing.T) {
+	// Trailing blanks are black pixels, and may have been removed by another editor
+	e := layeredEditor(t, false)
+	var trimmed []string
+	for _, line := range strings.Split(e.LayersText(), "\n") {
+		trimmed = append(trimmed, strings.TrimRight(line, " "))
+	}
+	frames, err := ReadLayeredImage([]byte(strings.Join(trimmed, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLayers(t, 0, e.FrameLayers(0), frames.list[0].layers)
+}
+
+func TestReadLayeredImageErrors(t *testing.T) {
+	for _, text := range []string{
+		"T T \nT T \n",
+		layersHeader + " 2 x 2\n",
+		layersHeader + " 0x2 active 1\n",
+		layersHeader + " 2x1 active 1\nT T \n",
+		layersHeader + " 2x1 active 1\nlayer visible 101 background\nT T \n",
+		layersHeader + " 2x1 active 2\nlayer visible 100 background\nT T \n",
+		layersHeader + " 2x1 active 1 frame 2\nframe 100 active 1\nlayer visible 100 background\nT T \n",
+	} {
+		if _, err := ReadLayeredImage([]byte(text)); err == nil {
+			t.Errorf("expected an error for:\n%s", text)
+		}
+	}
+}
+
+func TestLayersFileRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "favicon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"favicon.png", "favicon.ico"} {
+		e := layeredEditor(t, true)
+		filename := filepath.Join(dir, name)
+		if err := e.Save(&filename, false); err != nil {
+			t.Fatal(err)
+		}
+		e2 := newTestEditor(1, 1, 0)
+		if _, err := e2.Load(nil, nil, filename); err != nil {
+			t.Fatal(err)
+		}
+		if e2.width != e.width || e2.height != e.height {
+			t.Fatalf("%s: expected %dx%d, got %dx%d", name, e.width, e.height, e2.width, e2.height)
+		}
+		if e2.frames.Len() != e.frames.Len() || e2.frames.active != e.frames.active {
+			t.Fatalf("%s: expected %d frames with %d active, got %d frames with %d active", name, e.frames.Len(), e.frames.active, e2.frames.Len(), e2.frames.active)
+		}
+		for i := range e.frames.list {
+			checkLayers(t, i, e.FrameLayers(i), e2.FrameLayers(i))
+		}
+	}
+}
+
+func TestLayersFileChangedImage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "favicon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	e := layeredEditor(t, false)
+	filename := filepath.Join(dir, "favicon.png")
+	if err := e.Save(&filename, false); err != nil {
+		t.Fatal(err)
+	}
+	// Change the image with another program, which does not know about the layers
+	if err := WriteEntries(filename, []*Pixels{NewPixels(e.width, e.height, 3)}); err != nil {
+		t.Fatal(err)
+	}
+	e2 := newTestEditor(1, 1, 0)
+	if _, err := e2.Load(nil, nil, filename); err != nil {
+		t.Fatal(err)
+	}
+	if !e2.Flat() || pixelsText(e2.Pixels()) != pixelsText(NewPixels(e.width, e.height, 3)) {
+		t.Errorf("expected the changed image without layers, got %d layers and:\n%s", e2.layers.Len(), pixelsText(e2.Pixels()))
+	}
+}
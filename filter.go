@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Kernel is a square convolution kernel, 3x3 or 5x5, with the weights row by row.
+// The weighted sum is divided by the divisor, and the offset is added, for intensities from 0 to 1.
+type Kernel struct {
+	size    int
+	weights []float64
+	divisor float64
+	offset  float64
+}
+
+// kernels are the built-in convolution kernels
+var kernels = map[string]Kernel{
+	"blur":    {3, []float64{1, 2, 1, 2, 4, 2, 1, 2, 1}, 16, 0},
+	"blur5":   {5, []float64{1, 4, 6, 4, 1, 4, 16, 24, 16, 4, 6, 24, 36, 24, 6, 4, 16, 24, 16, 4, 1, 4, 6, 4, 1}, 256, 0},
+	"sharpen": {3, []float64{0, -1, 0, -1, 5, -1, 0, -1, 0}, 1, 0},
+	"edge":    {3, []float64{-1, -1, -1, -1, 8, -1, -1, -1, -1}, 1, 0},
+	"emboss":  {3, []float64{-2, -1, 0, -1, 1, 1, 0, 1, 2}, 1, 0.5},
+}
+
+// ParseKernel returns the built-in kernel with the given name, or a custom kernel from 9 or 25
+// comma separated weights, like "0,-1,0,-1,5,-1,0,-1,0". Custom kernels are divided by the sum
+// of the weights, unless the sum is 0.
+func ParseKernel(s string) (Kernel, error) {
+	if k, ok := kernels[strings.ToLower(s)]; ok {
+		return k, nil
+	}
+	fields := strings.Split(s, ",")
+	if len(fields) != 9 && len(fields) != 25 {
+		return Kernel{}, errors.New("the kernel must be blur, blur5, sharpen, edge, emboss or 9 or 25 comma separated weights")
+	}
+	k := Kernel{size: 3, weights: make([]float64, len(fields))}
+	if len(fields) == 25 {
+		k.size = 5
+	}
+	for i, field := range fields {
+		w, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return Kernel{}, fmt.Errorf("invalid weight: %s", field)
+		}
+		k.weights[i] = w
+		k.divisor += w
+	}
+	if k.divisor == 0 {
+		k.divisor = 1
+	}
+	return k, nil
+}
+
+// Convolve returns the intensities of the pixels, from 0 to 1, after applying the kernel.
+// Neighbours outside of the grid are taken from the closest edge if wrap is false, or from the opposite edge if it is true.
+// Transparent neighbours count as the pixel in the middle, and transparent pixels are not changed.
+func (p *Pixels) Convolve(k Kernel, wrap bool) []float64 {
+	var (
+		result = make([]float64, len(p.values))
+		r      = k.size / 2
+	)
+	for y := 0; y < p.h; y++ {
+		for x := 0; x < p.w; x++ {
+			center := p.At(x, y)
+			if center == transparent {
+				continue
+			}
+			sum := 0.0
+			for ky := -r; ky <= r; ky++ {
+				for kx := -r; kx <= r; kx++ {
+					var v byte
+					if wrap {
+						v = p.At(mod(x+kx, p.w), mod(y+ky, p.h))
+					} else {
+						v = p.clampAt(x+kx, y+ky)
+					}
+					if v == transparent {
+						v = center
+					}
+					sum += k.weights[(ky+r)*k.size+kx+r] * float64(v) / 15
+				}
+			}
+			result[y*p.w+x] = sum/k.divisor + k.offset
+		}
+	}
+	return result
+}
+
+// Quantize returns a copy of the pixels, with the given intensities from 0 to 1 rounded to the closest of the 16 gray levels.
+// If dither is true, the rounding errors are spread to the neighbouring pixels (Floyd-Steinberg).
+// Transparent pixels are kept transparent.
+func (p *Pixels) Quantize(intensities []float64, dither bool) *Pixels {
+	var (
+		p2     = p.Copy()
+		values = append([]float64{}, intensities...)
+	)
+	// spread adds a part of the rounding error to the pixel at x,y, if it is not transparent
+	spread := func(x, y int, err float64) {
+		if x >= 0 && y >= 0 && x < p.w && y < p.h && p.At(x, y) != transparent {
+			values[y*p.w+x] += err
+		}
+	}
+	for y := 0; y < p.h; y++ {
+		for x := 0; x < p.w; x++ {
+			if p.At(x, y) == transparent {
+				continue
+			}
+			v := clamp01(values[y*p.w+x])
+			level := math.Round(v * 15)
+			p2.Set(x, y, byte(level))
+			if dither {
+				err := v - level/15
+				spread(x+1, y, err*7/16)
+				spread(x-1, y+1, err*3/16)
+				spread(x, y+1, err*5/16)
+				spread(x+1, y+1, err*1/16)
+			}
+		}
+	}
+	return p2
+}
+
+// filterCommand applies a convolution kernel to the selection, or to the whole image, like "filter blur wrap dither"
+func filterCommand(e *Editor, args []string) (string, error) {
+	usage := errors.New("usage: filter blur|blur5|sharpen|edge|emboss|WEIGHTS [clamp|wrap] [dither]")
+	if len(args) < 1 || len(args) > 3 {
+		return "", usage
+	}
+	k, err := ParseKernel(args[0])
+	if err != nil {
+		return "", err
+	}
+	var wrap, dither bool
+	for _, arg := range args[1:] {
+		switch strings.ToLower(arg) {
+		case "clamp":
+			wrap = false
+		case "wrap":
+			wrap = true
+		case "dither":
+			dither = true
+		default:
+			return "", usage
+		}
+	}
+	return "Filtered the " + e.where() + " with " + args[0], e.Transform(func(p *Pixels) (*Pixels, error) {
+		return p.Quantize(p.Convolve(k, wrap), dither), nil
+	})
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"strings"
+)
+
+// Font is a tiny bitmap font, for stamping text into icons.
+// Each glyph is given as rows of '#' and '.', separated by spaces, and all glyphs are as tall as the font.
+// Glyphs can be narrower than the font, so that letters like I and punctuation take up less room.
+type Font struct {
+	name    string
+	height  int
+	spacing int // the default number of blank columns between glyphs
+	glyphs  map[rune]string
+}
+
+// fontNames are the names of the built-in fonts, from the smallest to the largest
+var fontNames = []string{"3x5", "4x6", "5x7", "8x8"}
+
+// fonts are the built-in fonts, by name. They have upper case letters, digits and some punctuation.
+var fonts = map[string]*Font{
+	"3x5": {"3x5", 5, 1, map[rune]string{
+		'A': ".#. #.# ### #.# #.#", 'B': "##. #.# ##. #.# ##.", 'C': ".## #.. #.. #.. .##",
+		'D': "##. #.# #.# #.# ##.", 'E': "### #.. ##. #.. ###", 'F': "### #.. ##. #.. #..",
+		'G': ".## #.. #.# #.# .##", 'H': "#.# #.# ### #.# #.#", 'I': "### .#. .#. .#. ###",
+		'J': "..# ..# ..# #.# .#.", 'K': "#.# #.# ##. #.# #.#", 'L': "#.. #.. #.. #.. ###",
+		'M': "#.# ### ### #.# #.#", 'N': "##. #.# #.# #.# #.#", 'O': "### #.# #.# #.# ###",
+		'P': "##. #.# ##. #.. #..", 'Q': ".#. #.# #.# ##. .##", 'R': "##. #.# ##. #.# #.#",
+		'S': ".## #.. .#. ..# ##.", 'T': "### .#. .#. .#. .#.", 'U': "#.# #.# #.# #.# ###",
+		'V': "#.# #.# #.# #.# .#.", 'W': "#.# #.# ### ### #.#", 'X': "#.# #.# .#. #.# #.#",
+		'Y': "#.# #.# .#. .#. .#.", 'Z': "### ..# .#. #.. ###",
+		'0': ".#. #.# #.# #.# .#.", '1': ".#. ##. .#. .#. ###", '2': "##. ..# .#. #.. ###",
+		'3': "##. ..# .#. ..# ##.", '4': "#.# #.# ### ..# ..#", '5': "### #.. ##. ..# ##.",
+		'6': ".## #.. ### #.# ###", '7': "### ..# .#. .#. .#.", '8': "### #.# ### #.# ###",
+		'9': "### #.# ### ..# ##.",
+		' ': ".. .. .. .. ..", '!': "# # # . #", '?': "##. ..# .#. ... .#.", '.': ". . . . #",
+		',': ".. .. .. .# #.", ':': ". # . # .", '-': "... ... ### ... ...", '+': "... .#. ### .#. ...",
+		'/': "..# ..# .#. #.. #..", '\'': "# # . . .", '(': ".# #. #. #. .#", ')': "#. .# .# .# #.",
+		'#': "#.# ### #.# ### #.#", '=': "... ### ... ### ...", '_': "... ... ... ... ###",
+		'*': "... #.# .#. #.# ...",
+	}},
+	"4x6": {"4x6", 6, 1, map[rune]string{
+		'A': ".##. #..# #..# #### #..# #..#", 'B': "###. #..# ###. #..# #..# ###.",
+		'C': ".### #... #... #... #... .###", 'D': "###. #..# #..# #..# #..# ###.",
+		'E': "#### #... ###. #... #... ####", 'F': "#### #... ###. #... #... #...",
+		'G': ".### #... #... #.## #..# .###", 'H': "#..# #..# #### #..# #..# #..#",
+		'I': "### .#. .#. .#. .#. ###", 'J': "..## ...# ...# ...# #..# .##.",
+		'K': "#..# #.#. ##.. #.#. #..# #..#", 'L': "#... #... #... #... #... ####",
+		'M': "#..# #### #### #..# #..# #..#", 'N': "#..# ##.# #.## #..# #..# #..#",
+		'O': ".##. #..# #..# #..# #..# .##.", 'P': "###. #..# #..# ###. #... #...",
+		'Q': ".##. #..# #..# #..# #.#. .#.#", 'R': "###. #..# #..# ###. #.#. #..#",
+		'S': ".### #... .##. ...# ...# ###.", 'T': "### .#. .#. .#. .#. .#.",
+		'U': "#..# #..# #..# #..# #..# .##.", 'V': "#..# #..# #..# #..# .##. .##.",
+		'W': "#..# #..# #..# #### #### #..#", 'X': "#..# #..# .##. .##. #..# #..#",
+		'Y': "#.# #.# #.# .#. .#. .#.", 'Z': "#### ...# ..#. .#.. #... ####",
+		'0': ".##. #..# #.## ##.# #..# .##.", '1': ".#. ##. .#. .#. .#. ###",
+		'2': ".##. #..# ..#. .#.. #... ####", '3': "###. ...# .##. ...# ...# ###.",
+		'4': "#..# #..# #### ...# ...# ...#", '5': "#### #... ###. ...# ...# ###.",
+		'6': ".##. #... ###. #..# #..# .##.", '7': "#### ...# ..#. .#.. .#.. .#..",
+		'8': ".##. #..# .##. #..# #..# .##.", '9': ".##. #..# #..# .### ...# .##.",
+		' ': ".. .. .. .. .. ..", '!': "# # # # . #", '?': ".##. #..# ..#. .#.. .... .#..",
+		'.': ". . . . . #", ',': ".. .. .. .. .# #.", ':': ". # . . # .",
+		'-': "... ... ### ... ... ...", '+': "... .#. ### .#. ... ...",
+		'/': "...# ...# ..#. .#.. #... #...", '\'': "# # . . . .",
+		'(': ".# #. #. #. #. .#", ')': "#. .# .# .# .# #.",
+		'#': ".##. #### .##. #### .##. ....", '=': ".... #### .... #### .... ....",
+		'_': ".... .... .... .... .... ####", '*': "... #.# .#. #.# ... ...",
+	}},
+	"5x7": {"5x7", 7, 1, map[rune]string{
+		'A': ".###. #...# #...# ##### #...# #...# #...#", 'B': "####. #...# #...# ####. #...# #...# ####.",
+		'C': ".###. #...# #.... #.... #.... #...# .###.", 'D': "####. #...# #...# #...# #...# #...# ####.",
+		'E': "##### #.... #.... ####. #.... #.... #####", 'F': "##### #.... #.... ####. #.... #.... #....",
+		'G': ".###. #...# #.... #.### #...# #...# .####", 'H': "#...# #...# #...# ##### #...# #...# #...#",
+		'I': "### .#. .#. .#. .#. .#. ###", 'J': "..### ...#. ...#. ...#. ...#. #..#. .##..",
+		'K': "#...# #..#. #.#.. ##... #.#.. #..#. #...#", 'L': "#.... #.... #.... #.... #.... #.... #####",
+		'M': "#...# ##.## #.#.# #.#.# #...# #...# #...#", 'N': "#...# #...# ##..# #.#.# #..## #...# #...#",
+		'O': ".###. #...# #...# #...# #...# #...# .###.", 'P': "####. #...# #...# ####. #.... #.... #....",
+		'Q': ".###. #...# #...# #...# #.#.# #..#. .##.#", 'R': "####. #...# #...# ####. #.#.. #..#. #...#",
+		'S': ".#### #.... #.... .###. ....# ....# ####.", 'T': "##### ..#.. ..#.. ..#.. ..#.. ..#.. ..#..",
+		'U': "#...# #...# #...# #...# #...# #...# .###.", 'V': "#...# #...# #...# #...# #...# .#.#. ..#..",
+		'W': "#...# #...# #...# #.#.# #.#.# #.#.# .#.#.", 'X': "#...# #...# .#.#. ..#.. .#.#. #...# #...#",
+		'Y': "#...# #...# .#.#. ..#.. ..#.. ..#.. ..#..", 'Z': "##### ....# ...#. ..#.. .#... #.... #####",
+		'0': ".###. #...# #..## #.#.# ##..# #...# .###.", '1': ".#. ##. .#. .#. .#. .#. ###",
+		'2': ".###. #...# ....# ...#. ..#.. .#... #####", '3': "##### ...#. ..#.. ...#. ....# #...# .###.",
+		'4': "...#. ..##. .#.#. #..#. ##### ...#. ...#.", '5': "##### #.... ####. ....# ....# #...# .###.",
+		'6': "..##. .#... #.... ####. #...# #...# .###.", '7': "##### ....# ...#. ..#.. .#... .#... .#...",
+		'8': ".###. #...# #...# .###. #...# #...# .###.", '9': ".###. #...# #...# .#### ....# ...#. .##..",
+		' ': "... ... ... ... ... ... ...", '!': "# # # # # . #",
+		'?': ".###. #...# ....# ...#. ..#.. ..... ..#..", '.': ".. .. .. .. .. ## ##",
+		',': ".. .. .. .. ## .# #.", ':': ".. ## ## .. ## ## ..",
+		'-': "..... ..... ..... ##### ..... ..... .....", '+': "..... ..#.. ..#.. ##### ..#.. ..#.. .....",
+		'/': "..... ....# ...#. ..#.. .#... #.... .....", '\'': "# # . . . . .",
+		'(': "..# .#. #.. #.. #.. .#. ..#", ')': "#.. .#. ..# ..# ..# .#. #..",
+		'#': ".#.#. .#.#. ##### .#.#. ##### .#.#. .#.#.", '=': "..... ..... ##### ..... ##### ..... .....",
+		'_': "..... ..... ..... ..... ..... ..... #####", '*': "..... ..#.. #.#.# .###. #.#.# ..#.. .....",
+	}},
+	"8x8": {"8x8", 8, 1, map[rune]string{
+		'A': "..###.. .##.##. ##...## ##...## ####### ##...## ##...## ##...##",
+		'B': "######. ##...## ##...## ######. ##...## ##...## ##...## ######.",
+		'C': ".#####. ##...## ##..... ##..... ##..... ##..... ##...## .#####.",
+		'D': "#####.. ##..##. ##...## ##...## ##...## ##...## ##..##. #####..",
+		'E': "####### ##..... ##..... ######. ##..... ##..... ##..... #######",
+		'F': "####### ##..... ##..... ######. ##..... ##..... ##..... ##.....",
+		'G': ".#####. ##...## ##..... ##..... ##..### ##...## ##...## .######",
+		'H': "##...## ##...## ##...## ####### ##...## ##...## ##...## ##...##",
+		'I': "###### ..##.. ..##.. ..##.. ..##.. ..##.. ..##.. ######",
+		'J': "...#### ....##. ....##. ....##. ....##. ##..##. ##..##. .####..",
+		'K': "##...## ##..##. ##.##.. ####... ####... ##.##.. ##..##. ##...##",
+		'L': "##..... ##..... ##..... ##..... ##..... ##..... ##..... #######",
+		'M': "##...## ###.### ####### ##.#.## ##...## ##...## ##...## ##...##",
+		'N': "##...## ###..## ####.## ##.#### ##..### ##...## ##...## ##...##",
+		'O': ".#####. ##...## ##...## ##...## ##...## ##...## ##...## .#####.",
+		'P': "######. ##...## ##...## ######. ##..... ##..... ##..... ##.....",
+		'Q': ".#####. ##...## ##...## ##...## ##...## ##.#.## ##..##. .###.##",
+		'R': "######. ##...## ##...## ######. ####... ##.##.. ##..##. ##...##",
+		'S': ".#####. ##...## ##..... .#####. .....## .....## ##...## .#####.",
+		'T': "###### ..##.. ..##.. ..##.. ..##.. ..##.. ..##.. ..##..",
+		'U': "##...## ##...## ##...## ##...## ##...## ##...## ##...## .#####.",
+		'V': "##...## ##...## ##...## ##...## ##...## .##.##. .##.##. ..###..",
+		'W': "##...## ##...## ##...## ##...## ##.#.## ####### ###.### ##...##",
+		'X': "##...## ##...## .##.##. ..###.. ..###.. .##.##. ##...## ##...##",
+		'Y': "##..## ##..## ##..## .####. ..##.. ..##.. ..##.. ..##..",
+		'Z': "####### .....## ....##. ...##.. ..##... .##.... ##..... #######",
+		'0': ".#####. ##...## ##..### ##.#### ####.## ###..## ##...## .#####.",
+		'1': "..##.. .###.. ####.. ..##.. ..##.. ..##.. ..##.. ######",
+		'2': ".#####. ##...## .....## ....##. ..###.. .##.... ##..... #######",
+		'3': ".#####. ##...## .....## ..####. .....## .....## ##...## .#####.",
+		'4': "....##. ...###. ..####. .##.##. ##..##. ####### ....##. ....##.",
+		'5': "####### ##..... ##..... ######. .....## .....## ##...## .#####.",
+		'6': "..####. .##.... ##..... ######. ##...## ##...## ##...## .#####.",
+		'7': "####### .....## ....##. ...##.. ..##... ..##... ..##... ..##...",
+		'8': ".#####. ##...## ##...## .#####. ##...## ##...## ##...## .#####.",
+		'9': ".#####. ##...## ##...## ##...## .###### .....## ....##. .####..",
+		' ': ".... .... .... .... .... .... .... ....", '!': "## ## ## ## ## .. ## ##",
+		'?': ".#####. ##...## .....## ...###. ..##... ....... ..##... ..##...",
+		'.': ".. .. .. .. .. .. ## ##", ',': "... ... ... ... ... .## .## ##.",
+		':': ".. ## ## .. .. ## ## ..", '-': "...... ...... ...... ###### ###### ...... ...... ......",
+		'+':  "...... ..##.. ..##.. ###### ###### ..##.. ..##.. ......",
+		'/':  ".....## .....## ....##. ...##.. ..##... .##.... ##..... ##.....",
+		'\'': "## ## ## .. .. .. .. ..",
+		'(':  "..## .##. ##.. ##.. ##.. ##.. .##. ..##", ')': "##.. .##. ..## ..## ..## ..## .##. ##..",
+		'#': ".##.##. .##.##. ####### .##.##. .##.##. ####### .##.##. .##.##.",
+		'=': "...... ###### ###### ...... ...... ###### ###### ......",
+		'_': "....... ....... ....... ....... ....... ....... ....... #######",
+		'*': "....... .#.#.#. ..###.. ####### ..###.. .#.#.#. ....... .......",
+	}},
+}
+
+// ParseFont returns the built-in font with the given name, like "5x7"
+func ParseFont(name string) (*Font, error) {
+	if f, ok := fonts[strings.ToLower(name)]; ok {
+		return f, nil
+	}
+	return nil, errors.New("the font must be " + strings.Join(fontNames, ", "))
+}
+
+// Glyph returns the pixels for the given rune, with 0 where the glyph is drawn and transparent elsewhere.
+// Lower case letters are drawn as upper case, and runes that are not in the font as a question mark.
+func (f *Font) Glyph(r rune) *Pixels {
+	s, ok := f.glyphs[r]
+	if !ok {
+		s, ok = f.glyphs[[]rune(strings.ToUpper(string(r)))[0]]
+	}
+	if !ok {
+		s = f.glyphs['?']
+	}
+	rows := strings.Fields(s)
+	p := NewPixels(len(rows[0]), len(rows), transparent)
+	for y, row := range rows {
+		for x, c := range row {
+			if c == '#' {
+				p.Set(x, y, 0)
+			}
+		}
+	}
+	return p
+}
+
+// Render returns the given lines of text, with 0 where the text is drawn and transparent elsewhere.
+// kern is added to the spacing between glyphs, and may be negative to draw them closer together.
+// The lines are aligned to the left, middle or right when align is 0, 1 or 2, and have one blank row between them.
+func (f *Font) Render(lines []string, kern int, align int) *Pixels {
+	var (
+		spacing = f.spacing + kern
+		rows    = make([]*Pixels, len(lines))
+		w       = 0
+	)
+	for i, line := range lines {
+		var glyphs []*Pixels
+		lineWidth := 0
+		for j, r := range line {
+			g := f.Glyph(r)
+			glyphs = append(glyphs, g)
+			lineWidth += g.Width()
+			if j > 0 {
+				lineWidth += spacing
+			}
+		}
+		row := NewPixels(lineWidth, f.height, transparent)
+		x := 0
+		for _, g := range glyphs {
+			// Paste only the drawn pixels, so that glyphs that are kerned closer do not erase each other
+			for gy := 0; gy < g.h; gy++ {
+				for gx := 0; gx < g.w; gx++ {
+					if g.At(gx, gy) != transparent {
+						row.Set(x+gx, gy, 0)
+					}
+				}
+			}
+			x += g.Width() + spacing
+		}
+		rows[i] = row
+		if row.Width() > w {
+			w = row.Width()
+		}
+	}
+	p := NewPixels(w, len(lines)*(f.height+1)-1, transparent)
+	for i, row := range rows {
+		p.Paste(row, image.Pt((w-row.Width())*align/2, i*(f.height+1)))
+	}
+	return p
+}
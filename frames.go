@@ -0,0 +1,286 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/xyproto/vt100"
+)
+
+// defaultFrameDelay is the delay of new frames, in milliseconds
+const defaultFrameDelay = 100
+
+// Frame is one image in an animation
+type Frame struct {
+	delay  int     // how long the frame is shown, in milliseconds
+	layers *Layers // the layers of the frame, with all pixels set, or nil for the active frame, which is in Editor.layers
+}
+
+// Frames is the list of frames in an animation. Images that are not animated have a single frame.
+type Frames struct {
+	list   []*Frame
+	active int // the index of the frame that is being edited
+}
+
+// NewFrames creates a frame list with a single frame, which is the active one
+func NewFrames() *Frames {
+	return &Frames{list: []*Frame{{delay: defaultFrameDelay}}}
+}
+
+// Len returns the number of frames
+func (fs *Frames) Len() int {
+	return len(fs.list)
+}
+
+// Active returns the frame that is being edited
+func (fs *Frames) Active() *Frame {
+	return fs.list[fs.active]
+}
+
+// Copy returns a deep copy of the frame list
+func (fs *Frames) Copy() *Frames {
+	fs2 := &Frames{list: make([]*Frame, len(fs.list)), active: fs.active}
+	for i, f := range fs.list {
+		f2 := *f
+		if f.layers != nil {
+			f2.layers = f.layers.Copy()
+		}
+		fs2.list[i] = &f2
+	}
+	return fs2
+}
+
+// share returns a copy of the frame list that shares the pixels with this one, which is cheaper than Copy
+func (fs *Frames) share() *Frames {
+	fs2 := &Frames{list: make([]*Frame, len(fs.list)), active: fs.active}
+	for i, f := range fs.list {
+		f2 := *f
+		if f.layers != nil {
+			f2.layers = f.layers.share()
+		}
+		fs2.list[i] = &f2
+	}
+	return fs2
+}
+
+// Equal returns true if the two frame lists are the same, not counting the layers of the active frame
+func (fs *Frames) Equal(other *Frames) bool {
+	if fs.active != other.active || len(fs.list) != len(other.list) {
+		return false
+	}
+	for i, f := range fs.list {
+		o := other.list[i]
+		if f.delay != o.delay || (f.layers == nil) != (o.layers == nil) {
+			return false
+		}
+		if f.layers != nil && !f.layers.Equal(o.layers) {
+			return false
+		}
+	}
+	return true
+}
+
+// memory returns the approximate number of bytes used by the frame list
+func (fs *Frames) memory() int {
+	const overhead = 64 // for the structs and the slice headers
+	n := overhead
+	for _, f := range fs.list {
+		n += overhead
+		if f.layers != nil {
+			n += f.layers.memory()
+		}
+	}
+	return n
+}
+
+// Flat returns true if the image has a single layer and a single frame, so that it can be saved as .ico or .png
+// without losing anything
+func (e *Editor) Flat() bool {
+	return e.layers.Len() == 1 && e.frames.Len() == 1
+}
+
+// FrameLayers returns a copy of the layers of the frame with the given index, with all pixels set
+func (e *Editor) FrameLayers(i int) *Layers {
+	if i == e.frames.active {
+		return e.AllLayers()
+	}
+	return e.frames.list[i].layers.Copy()
+}
+
+// FrameComposite returns the visible layers of the frame with the given index, drawn on top of each other
+func (e *Editor) FrameComposite(i int) *Pixels {
+	if i == e.frames.active {
+		return e.Composite()
+	}
+	return e.frames.list[i].layers.flatten(e.width, e.height)
+}
+
+// SelectFrame will make the frame with the given index the one that is being edited
+func (e *Editor) SelectFrame(i int) error {
+	if i < 0 || i >= e.frames.Len() {
+		return fmt.Errorf("there is no frame %d", i+1)
+	}
+	if i == e.frames.active {
+		return nil
+	}
+	e.frames.Active().layers = e.AllLayers()
+	e.frames.active = i
+	e.layers = e.frames.Active().layers
+	e.frames.Active().layers = nil
+	e.SetPixels(e.layers.Active().pixels)
+	e.layers.Active().pixels = nil
+	e.redraw = true
+	return nil
+}
+
+// insertFrame will insert the given frame after the active one, and select it
+func (e *Editor) insertFrame(f *Frame) {
+	i := e.frames.active + 1
+	e.frames.list = append(e.frames.list[:i], append([]*Frame{f}, e.frames.list[i:]...)...)
+	e.SelectFrame(i)
+}
+
+// AddFrame will add a frame with a single transparent layer after the active frame, and select it
+func (e *Editor) AddFrame() {
+	layers := NewLayers("background")
+	layers.Active().pixels = NewPixels(e.width, e.height, transparent)
+	e.insertFrame(&Frame{delay: e.frames.Active().delay, layers: layers})
+}
+
+// DuplicateFrame will add a copy of the active frame after it, and select it
+func (e *Editor) DuplicateFrame() {
+	e.insertFrame(&Frame{delay: e.frames.Active().delay, layers: e.AllLayers()})
+}
+
+// DeleteFrame will remove the active frame, and select the one before it
+func (e *Editor) DeleteFrame() error {
+	if e.frames.Len() == 1 {
+		return errors.New("can not delete the only frame")
+	}
+	i := e.frames.active
+	next := i - 1
+	if next < 0 {
+		next = 1
+	}
+	e.SelectFrame(next)
+	e.frames.list = append(e.frames.list[:i], e.frames.list[i+1:]...)
+	if e.frames.active > i {
+		e.frames.active--
+	}
+	return nil
+}
+
+// drawOnionSkin will draw the pixels of the previous frame, in the guide color,
+// where the pixels of the current frame are transparent
+func (e *Editor) drawOnionSkin(c *vt100.Canvas, cx, cy int) {
+	if e.frames.active == 0 {
+		return
+	}
+	var (
+		prev    = e.FrameComposite(e.frames.active - 1)
+		current = e.Composite()
+	)
+	for y := e.pixelScroll; y < e.height; y++ {
+		for x := 0; x < e.width; x++ {
+			if v := prev.At(x, y); current.At(x, y) == transparent && v != transparent {
+				e.drawPixel(c, cx, cy, x, y, valueRune(v), e.guideFg, e.bg)
+			}
+		}
+	}
+}
+
+// drawFrames will draw the current frame number and delay below the list of layers
+func (e *Editor) drawFrames(c *vt100.Canvas, fromline, toline, cx, cy int) {
+	if e.frames.Len() < 2 {
+		return
+	}
+	var (
+		x    = cx + e.gridWidth() + legendGap
+		y    = len(legendLines()) + 1
+		line = fmt.Sprintf("Frame %d/%d, %d ms", e.frames.active+1, e.frames.Len(), e.frames.Active().delay)
+	)
+	if e.layers.Len() > 1 {
+		// below the "Layers:" line and the layers
+		y += e.layers.Len() + 2
+	}
+	if y < fromline || y >= toline || x+len(line) >= int(c.W()) {
+		return
+	}
+	c.Write(uint(x), uint(cy+y-fromline), e.fg, e.bg, line)
+}
+
+// frameCommand adds, duplicates, deletes or selects frames, sets the delay of the active frame,
+// toggles the onion skin or exports the animation
+func frameCommand(e *Editor, args []string) (string, error) {
+	usage := errors.New("usage: frame add|duplicate|delete|next|prev|select N|delay MS|onion|export gif|apng")
+	if len(args) == 0 {
+		return "", usage
+	}
+	switch strings.ToLower(args[0]) {
+	case "add", "new":
+		e.AddFrame()
+	case "duplicate", "dup":
+		e.DuplicateFrame()
+	case "delete", "remove":
+		if err := e.DeleteFrame(); err != nil {
+			return "", err
+		}
+	case "next":
+		if err := e.SelectFrame((e.frames.active + 1) % e.frames.Len()); err != nil {
+			return "", err
+		}
+	case "prev", "previous":
+		if err := e.SelectFrame((e.frames.active + e.frames.Len() - 1) % e.frames.Len()); err != nil {
+			return "", err
+		}
+	case "select":
+		if len(args) != 2 {
+			return "", usage
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			return "", usage
+		}
+		if err := e.SelectFrame(n - 1); err != nil {
+			return "", err
+		}
+	case "delay":
+		if len(args) != 2 {
+			return "", usage
+		}
+		delay, err := strconv.Atoi(strings.TrimSuffix(args[1], "ms"))
+		if err != nil || delay < 10 || delay > 65535 {
+			return "", errors.New("the delay must be from 10 to 65535 ms")
+		}
+		e.frames.Active().delay = delay
+	case "onion":
+		e.onionSkin = !e.onionSkin
+		if e.onionSkin {
+			return "Onion skin on", nil
+		}
+		return "Onion skin off", nil
+	case "export":
+		if len(args) != 2 {
+			return "", usage
+		}
+		filename := strings.TrimSuffix(strings.TrimSuffix(e.filename, ".ico"), ".png")
+		filename = strings.TrimSuffix(filename, ".fav")
+		switch strings.ToLower(args[1]) {
+		case "gif":
+			filename += ".gif"
+		case "apng":
+			filename += ".apng"
+		default:
+			return "", usage
+		}
+		if err := e.ExportAnimation(filename); err != nil {
+			return "", err
+		}
+		return "Exported " + strconv.Itoa(e.frames.Len()) + " frames to " + filename, nil
+	default:
+		return "", usage
+	}
+	return fmt.Sprintf("Frame %d/%d, %d ms", e.frames.active+1, e.frames.Len(), e.frames.Active().delay), nil
+}
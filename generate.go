@@ -0,0 +1,259 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// defaultSizes are the sizes of the generated icons, unless FAVICON_SIZES or the sizes option is set
+const defaultSizes = "16,32,48"
+
+// generator generates an icon with initials on a shape, or a symmetric identicon, from a string
+type generator struct {
+	kind       string // "initials" or "identicon"
+	text       string // the initials, or a name to take them from, or the seed for the identicon
+	shape      string // "circle", "rounded" or "square", for initials
+	value      byte   // the value of the initials or the identicon pattern
+	background byte   // the value of the shape, or around the identicon pattern
+	sizes      []int  // the sizes to generate, for .ico files
+}
+
+// generateUsage is the usage message for the generate command, without the command name
+const generateUsage = "initials|identicon TEXT [shape circle|rounded|square] [value 0-F] [background 0-F|T] [sizes 16,32,48]"
+
+// ParseSizes parses a comma separated list of sizes, like "16,32,48", for square icons
+func ParseSizes(s string) ([]int, error) {
+	var sizes []int
+	seen := make(map[int]bool)
+	for _, field := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n < 1 || n > maxImageSize {
+			return nil, fmt.Errorf("the sizes must be from 1 to %d, like %s", maxImageSize, defaultSizes)
+		}
+		if !seen[n] {
+			sizes = append(sizes, n)
+			seen[n] = true
+		}
+	}
+	return sizes, nil
+}
+
+// configuredSizes returns the sizes from the FAVICON_SIZES environment variable, or the default sizes
+func configuredSizes() ([]int, error) {
+	if s := os.Getenv("FAVICON_SIZES"); s != "" {
+		return ParseSizes(s)
+	}
+	return ParseSizes(defaultSizes)
+}
+
+// parseGenerateArgs parses the kind of icon, the text and the options, like "initials billing-api shape rounded".
+// The values that are not given are picked from a hash of the text, so that each text gets its own icon.
+func parseGenerateArgs(args []string) (*generator, error) {
+	usage := errors.New("usage: generate " + generateUsage)
+	if len(args) < 2 || len(args)%2 != 0 {
+		return nil, usage
+	}
+	g := &generator{kind: strings.ToLower(args[0]), text: args[1], shape: "circle"}
+	if g.kind != "initials" && g.kind != "identicon" {
+		return nil, usage
+	}
+	sizes, err := configuredSizes()
+	if err != nil {
+		return nil, err
+	}
+	g.sizes = sizes
+	hash := sha256.Sum256([]byte(g.text))
+	if g.kind == "initials" {
+		// A gray shape, with initials in black or white, whichever stands out the most
+		g.background = 2 + hash[0]%10
+		g.value = 15
+		if g.background >= 8 {
+			g.value = 0
+		}
+	} else {
+		g.value = 6 + hash[0]%10
+		g.background = transparent
+	}
+	for i := 2; i < len(args); i += 2 {
+		switch strings.ToLower(args[i]) {
+		case "shape":
+			g.shape = strings.ToLower(args[i+1])
+			if g.kind != "initials" || (g.shape != "circle" && g.shape != "rounded" && g.shape != "square") {
+				return nil, errors.New("the shape must be circle, rounded or square, for initials")
+			}
+		case "value":
+			if g.value, err = ParseValue(args[i+1]); err != nil {
+				return nil, err
+			}
+		case "background":
+			if g.background, err = ParseValue(args[i+1]); err != nil {
+				return nil, err
+			}
+		case "sizes":
+			if g.sizes, err = ParseSizes(args[i+1]); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, usage
+		}
+	}
+	return g, nil
+}
+
+// initials returns up to three letters or digits for the given name. A name like "billing-api" gives "BA",
+// while short names like "CI" are used as they are.
+func initials(name string) string {
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 {
+		return "?"
+	}
+	if len(words) == 1 && len([]rune(words[0])) <= 3 {
+		return strings.ToUpper(words[0])
+	}
+	var sb strings.Builder
+	for i, word := range words {
+		if i == 2 {
+			break
+		}
+		sb.WriteRune(unicode.ToUpper([]rune(word)[0]))
+	}
+	return sb.String()
+}
+
+// inShape returns true if the pixel at x,y is within the shape, which is as large as fits in w x h pixels, in the middle
+func inShape(shape string, x, y, w, h int) bool {
+	var (
+		s  = float64(w)
+		dx = float64(x) + 0.5 - float64(w)/2
+		dy = float64(y) + 0.5 - float64(h)/2
+	)
+	if h < w {
+		s = float64(h)
+	}
+	if math.Abs(dx) > s/2 || math.Abs(dy) > s/2 {
+		return false
+	}
+	switch shape {
+	case "circle":
+		return math.Hypot(dx, dy) <= s/2
+	case "rounded":
+		// Cut the corners with a quarter of a circle, with a radius that is a quarter of the size
+		r := s / 4
+		cx, cy := math.Max(math.Abs(dx)-(s/2-r), 0), math.Max(math.Abs(dy)-(s/2-r), 0)
+		return math.Hypot(cx, cy) <= r
+	}
+	return true
+}
+
+// Generate returns the icon with the given size
+func (g *generator) Generate(w, h int) *Pixels {
+	if g.kind == "identicon" {
+		return identicon(g.text, w, h, g.value, g.background)
+	}
+	p := NewPixels(w, h, transparent)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if inShape(g.shape, x, y, w, h) {
+				p.Set(x, y, g.background)
+			}
+		}
+	}
+	// Keep the initials away from the edges of the shape
+	area := image.Rect(w/8, h/8, w-w/8, h-h/8)
+	o := textOptions{anchor: anchors["c"], anchored: true, lines: []string{initials(g.text)}}
+	t := o.render(area)
+	return stampText(p, t, o.position(area, t), g.value)
+}
+
+// identicon returns a 5x5 pattern that is mirrored from left to right, from a hash of the seed, scaled up to fit in w x h pixels
+func identicon(seed string, w, h int, v, background byte) *Pixels {
+	var (
+		hash = sha256.Sum256([]byte(seed))
+		s    = w
+	)
+	if h < s {
+		s = h
+	}
+	cell := s / 5
+	if cell < 1 {
+		cell = 1
+	}
+	var (
+		p      = NewPixels(w, h, background)
+		offset = image.Pt((w-5*cell)/2, (h-5*cell)/2)
+	)
+	for y := 0; y < 5; y++ {
+		for x := 0; x < 3; x++ {
+			// The first byte of the hash is used for the value, and the next ones for the pattern
+			bit := y*3 + x
+			if hash[1+bit/8]&(1<<uint(bit%8)) == 0 {
+				continue
+			}
+			for cy := 0; cy < cell; cy++ {
+				for cx := 0; cx < cell; cx++ {
+					p.Set(offset.X+x*cell+cx, offset.Y+y*cell+cy, v)
+					p.Set(offset.X+(4-x)*cell+cx, offset.Y+y*cell+cy, v)
+				}
+			}
+		}
+	}
+	return p
+}
+
+// generateCommand replaces the image with generated initials or an identicon, like "generate initials billing-api",
+// and adds .ico entries in the other sizes
+func generateCommand(e *Editor, args []string) (string, error) {
+	g, err := parseGenerateArgs(args)
+	if err != nil {
+		return "", err
+	}
+	e.SetPixels(g.Generate(e.width, e.height))
+	for _, size := range g.sizes {
+		if size != e.width || size != e.height {
+			e.AddEntry(g.Generate(size, size))
+		}
+	}
+	msg := "Generated " + g.kind + " for " + g.text
+	if len(e.entries) > 0 {
+		msg += ". The other entries are: " + e.entrySizes()
+	}
+	return msg, nil
+}
+
+// generateSubcommand writes generated initials or an identicon to a file, like "generate initials billing-api billing.ico".
+// .ico files get an entry for each size, while .png and .fav files only get the first size.
+func generateSubcommand(args []string) (string, error) {
+	usage := errors.New("usage: generate initials|identicon TEXT OUTPUT [shape circle|rounded|square] [value 0-F] [background 0-F|T] [sizes 16,32,48]")
+	if len(args) < 3 {
+		return "", usage
+	}
+	output := args[2]
+	g, err := parseGenerateArgs(append(args[:2:2], args[3:]...))
+	if err != nil {
+		return "", err
+	}
+	var (
+		entries []*Pixels
+		sizes   []string
+	)
+	for _, size := range g.sizes {
+		entries = append(entries, g.Generate(size, size))
+		sizes = append(sizes, fmt.Sprintf("%dx%d", size, size))
+	}
+	if !strings.HasSuffix(output, ".ico") {
+		sizes = sizes[:1]
+	}
+	if err := WriteEntries(output, entries); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Wrote %s for %s to %s, as %s", g.kind, g.text, output, strings.Join(sizes, ", ")), nil
+}
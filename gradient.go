@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strings"
+)
+
+// Gradient returns pixels of the given size, filled with a gradient from one value to another.
+// The shape is "linear", "radial" or "diamond". Linear gradients go from the left to the right, unless the direction
+// is "vertical" (from the top to the bottom) or "diagonal" (from the upper left to the lower right corner).
+// Radial and diamond gradients go from the middle to the edges. With dither, the gradient is dithered
+// with an ordered pattern, instead of being rounded to the closest gray level.
+func Gradient(w, h int, shape, direction string, from, to byte, dither bool) *Pixels {
+	p := NewPixels(w, h, from)
+	// fraction returns a value from 0 to 1, for how far into the gradient the pixel at x,y is
+	fraction := func(x, y int) float64 {
+		var (
+			// the middle of the pixel, relative to the middle of the image, from -1 to 1
+			dx = (float64(x)+0.5)/float64(w)*2 - 1
+			dy = (float64(y)+0.5)/float64(h)*2 - 1
+		)
+		switch shape {
+		case "radial":
+			return math.Hypot(dx, dy)
+		case "diamond":
+			return math.Abs(dx) + math.Abs(dy)
+		}
+		switch direction {
+		case "vertical":
+			return (dy + 1) / 2
+		case "diagonal":
+			return (dx + dy + 2) / 4
+		}
+		return (dx + 1) / 2
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := float64(from) + (float64(to)-float64(from))*clamp01(fraction(x, y))
+			if dither {
+				v = math.Floor(v + bayerThreshold(x, y))
+			} else {
+				v = math.Round(v)
+			}
+			p.Set(x, y, byte(math.Max(0, math.Min(15, v))))
+		}
+	}
+	return p
+}
+
+// gradientCommand fills the selection, or the whole image, with a gradient, like "gradient radial f 0 dither"
+func gradientCommand(e *Editor, args []string) (string, error) {
+	usage := errors.New("usage: gradient linear|radial|diamond FROM TO [horizontal|vertical|diagonal] [dither], where FROM and TO are 0-F")
+	if len(args) < 3 || len(args) > 5 {
+		return "", usage
+	}
+	shape := strings.ToLower(args[0])
+	if shape != "linear" && shape != "radial" && shape != "diamond" {
+		return "", usage
+	}
+	from, err := ParseValue(args[1])
+	if err != nil || from == transparent {
+		return "", usage
+	}
+	to, err := ParseValue(args[2])
+	if err != nil || to == transparent {
+		return "", usage
+	}
+	direction, dither := "horizontal", false
+	for _, arg := range args[3:] {
+		switch strings.ToLower(arg) {
+		case "horizontal", "vertical", "diagonal":
+			if shape != "linear" {
+				return "", errors.New("only linear gradients have a direction")
+			}
+			direction = strings.ToLower(arg)
+		case "dither":
+			dither = true
+		default:
+			return "", usage
+		}
+	}
+	return "Filled the " + e.where() + " with a " + shape + " gradient", e.Transform(func(p *Pixels) (*Pixels, error) {
+		return Gradient(p.Width(), p.Height(), shape, direction, from, to, dither), nil
+	})
+}
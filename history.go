@@ -0,0 +1,375 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// savedPosition is a Position, as it is stored in the undo history file
+type savedPosition struct {
+	SX          int
+	SY          int
+	Offset      int
+	ScrollSpeed int
+	SavedX      int
+}
+
+// savedLine is a lineDelta, as it is stored in the undo history file
+type savedLine struct {
+	Y         int
+	Before    string
+	After     string
+	HadBefore bool
+	HasAfter  bool
+}
+
+// savedLayers is a layer stack, as it is stored in the undo history file.
+// The pixels of each layer are stored in the textual representation, or as an empty string for the active layer.
+type savedLayers struct {
+	Active int
+	Names  []string
+	Hidden []bool
+	Opaque []int
+	Pixels []string
+}
+
+// savedFrames is a frame list, as it is stored in the undo history file.
+// The layers of the active frame are stored as nil.
+type savedFrames struct {
+	Active int
+	Delays []int
+	Layers []*savedLayers
+}
+
+// savedStep is an undoStep, as it is stored in the undo history file.
+// Parent is the ID of the state before this step, or -1 for the oldest state.
+type savedStep struct {
+	ID           int
+	Parent       int
+	Name         string
+	What         string
+	Count        int
+	Lines        []savedLine
+	PosBefore    savedPosition
+	PosAfter     savedPosition
+	SizeBefore   [2]int
+	SizeAfter    [2]int
+	LayersBefore *savedLayers `json:",omitempty"`
+	LayersAfter  *savedLayers `json:",omitempty"`
+	FramesBefore *savedFrames `json:",omitempty"`
+	FramesAfter  *savedFrames `json:",omitempty"`
+	// The other .ico entries, in the textual representation, if the step changed them
+	Entries       bool     `json:",omitempty"`
+	EntriesBefore []string `json:",omitempty"`
+	EntriesAfter  []string `json:",omitempty"`
+}
+
+// savedHistory is the contents of an undo history file.
+// Hash is the SHA-256 hash of the image file and of its layers file, when the history was stored.
+// Layers and Frames are the number of layers in the active frame, and the number of frames, at that time.
+// The steps are sorted by ID, so that each step comes after the state it was made from.
+type savedHistory struct {
+	Hash    string
+	Steps   []savedStep
+	Current int
+	NextID  int
+	Layers  int
+	Frames  int
+}
+
+// historyDir returns the directory where undo history files are stored, following the XDG base directory specification
+func historyDir() (string, error) {
+	stateHome := os.Getenv("XDG_STATE_HOME")
+	if stateHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		stateHome = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(stateHome, "favicon", "history"), nil
+}
+
+// historyFilename returns the path to the undo history file for the given image file
+func historyFilename(filename string) (string, error) {
+	dir, err := historyDir()
+	if err != nil {
+		return "", err
+	}
+	absFilename, err := filepath.Abs(filename)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(absFilename))
+	return filepath.Join(dir, hex.EncodeToString(sum[:])+".json.gz"), nil
+}
+
+// fileHash returns the SHA-256 hash of the contents of the given file, and of the layers and frames that were
+// saved next to it, if there are any, as a hex string
+func fileHash(filename string) (string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write(data)
+	if !strings.HasSuffix(filename, ".fav") {
+		if layers, err := ioutil.ReadFile(LayersFilename(filename)); err == nil {
+			h.Write(layers)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// savePosition converts a Position to the form that is stored in the undo history file
+func savePosition(p Position) savedPosition {
+	return savedPosition{p.sx, p.sy, p.offset, p.scrollSpeed, p.savedX}
+}
+
+// position converts a stored position back to a Position
+func (sp savedPosition) position() Position {
+	return Position{sp.SX, sp.SY, sp.Offset, sp.ScrollSpeed, sp.SavedX}
+}
+
+// saveLayers converts a layer stack to the form that is stored in the undo history file
+func saveLayers(ls *Layers) *savedLayers {
+	if ls == nil {
+		return nil
+	}
+	sl := &savedLayers{Active: ls.active}
+	for _, l := range ls.stack {
+		text := ""
+		if l.pixels != nil {
+			text = pixelsText(l.pixels)
+		}
+		sl.Names = append(sl.Names, l.name)
+		sl.Hidden = append(sl.Hidden, !l.visible)
+		sl.Opaque = append(sl.Opaque, l.opacity)
+		sl.Pixels = append(sl.Pixels, text)
+	}
+	return sl
+}
+
+// layers converts a stored layer stack back to a layer stack, for images of the given size
+func (sl *savedLayers) layers(w, h int) *Layers {
+	if sl == nil {
+		return nil
+	}
+	ls := &Layers{active: sl.Active}
+	for i, name := range sl.Names {
+		l := &Layer{name: name, visible: !sl.Hidden[i], opacity: sl.Opaque[i]}
+		if sl.Pixels[i] != "" {
+			l.pixels = textPixels(sl.Pixels[i], w, h)
+		}
+		ls.stack = append(ls.stack, l)
+	}
+	return ls
+}
+
+// textPixels converts the textual representation of an image back to w x h pixels
+func textPixels(text string, w, h int) *Pixels {
+	p := NewPixels(w, h, transparent)
+	for y, line := range strings.Split(text, "\n") {
+		runes := []rune(line)
+		for x := 0; x*2 < len(runes); x++ {
+			p.Set(x, y, runeValue(runes[x*2]))
+		}
+	}
+	return p
+}
+
+// saveEntries converts .ico entries to the form that is stored in the undo history file
+func saveEntries(entries []*Pixels) []string {
+	texts := make([]string, len(entries))
+	for i, entry := range entries {
+		texts[i] = pixelsText(entry)
+	}
+	return texts
+}
+
+// loadEntries converts stored .ico entries back to images
+func loadEntries(texts []string) []*Pixels {
+	var entries []*Pixels
+	for _, text := range texts {
+		w, h := textSize(text)
+		entries = append(entries, textPixels(text, w, h))
+	}
+	return entries
+}
+
+// saveFrames converts a frame list to the form that is stored in the undo history file
+func saveFrames(fs *Frames) *savedFrames {
+	if fs == nil {
+		return nil
+	}
+	sf := &savedFrames{Active: fs.active}
+	for _, f := range fs.list {
+		sf.Delays = append(sf.Delays, f.delay)
+		sf.Layers = append(sf.Layers, saveLayers(f.layers))
+	}
+	return sf
+}
+
+// frames converts a stored frame list back to a frame list, for images of the given size
+func (sf *savedFrames) frames(w, h int) *Frames {
+	if sf == nil {
+		return nil
+	}
+	fs := &Frames{active: sf.Active}
+	for i, delay := range sf.Delays {
+		fs.list = append(fs.list, &Frame{delay: delay, layers: sf.Layers[i].layers(w, h)})
+	}
+	return fs
+}
+
+// saveSteps converts undo steps to the form that is stored in the undo history file
+func saveSteps(steps []*undoStep) []savedStep {
+	saved := make([]savedStep, 0, len(steps))
+	for _, step := range steps {
+		parent := -1
+		if step.parent != nil {
+			parent = step.parent.id
+		}
+		ss := savedStep{step.id, parent, step.name, step.what, step.count, nil, savePosition(step.posBefore), savePosition(step.posAfter), step.sizeBefore, step.sizeAfter, saveLayers(step.layersBefore), saveLayers(step.layersAfter), saveFrames(step.framesBefore), saveFrames(step.framesAfter), step.entries, nil, nil}
+		if step.entries {
+			ss.EntriesBefore = saveEntries(step.entriesBefore)
+			ss.EntriesAfter = saveEntries(step.entriesAfter)
+		}
+		for y, d := range step.lines {
+			ss.Lines = append(ss.Lines, savedLine{y, string(d.before), string(d.after), d.hadBefore, d.hasAfter})
+		}
+		saved = append(saved, ss)
+	}
+	return saved
+}
+
+// loadSteps converts stored undo steps back to a tree of undo steps, and returns the steps by ID
+func loadSteps(saved []savedStep) (map[int]*undoStep, error) {
+	steps := make(map[int]*undoStep, len(saved))
+	for _, ss := range saved {
+		step := &undoStep{id: ss.ID, what: ss.What, count: ss.Count, lines: make(map[int]*lineDelta, len(ss.Lines)), posBefore: ss.PosBefore.position(), posAfter: ss.PosAfter.position(), sizeBefore: ss.SizeBefore, sizeAfter: ss.SizeAfter, name: ss.Name}
+		step.layersBefore = ss.LayersBefore.layers(ss.SizeBefore[0], ss.SizeBefore[1])
+		step.layersAfter = ss.LayersAfter.layers(ss.SizeAfter[0], ss.SizeAfter[1])
+		step.framesBefore = ss.FramesBefore.frames(ss.SizeBefore[0], ss.SizeBefore[1])
+		step.framesAfter = ss.FramesAfter.frames(ss.SizeAfter[0], ss.SizeAfter[1])
+		if ss.Entries {
+			step.entries = true
+			step.entriesBefore = loadEntries(ss.EntriesBefore)
+			step.entriesAfter = loadEntries(ss.EntriesAfter)
+		}
+		for _, sl := range ss.Lines {
+			step.lines[sl.Y] = &lineDelta{[]rune(sl.Before), []rune(sl.After), sl.HadBefore, sl.HasAfter}
+		}
+		if ss.Parent >= 0 {
+			parent, ok := steps[ss.Parent]
+			if !ok {
+				return nil, errors.New("the undo history is inconsistent")
+			}
+			step.parent = parent
+			parent.children = append(parent.children, step)
+		}
+		steps[step.id] = step
+	}
+	return steps, nil
+}
+
+// SaveHistory will store the undo history for the given image file, which should just have been saved
+func (u *Undo) SaveHistory(e *Editor, filename string) error {
+	hash, err := fileHash(filename)
+	if err != nil {
+		return err
+	}
+	historyFile, err := historyFilename(filename)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(historyFile), 0700); err != nil {
+		return err
+	}
+
+	states := u.States()
+	u.mut.RLock()
+	h := savedHistory{hash, saveSteps(states), u.current.id, u.nextID, e.layers.Len(), e.frames.Len()}
+	u.mut.RUnlock()
+
+	f, err := os.Create(historyFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	if err := json.NewEncoder(gz).Encode(h); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// LoadHistory will load the undo history for the given image file, if there is one.
+// The file must already be loaded into the editor, including its layers and frames.
+// If the file has been changed since the history was stored, or the layers and frames
+// could not be loaded, the history is removed instead.
+// Returns true if an undo history was loaded.
+func (u *Undo) LoadHistory(e *Editor, filename string) (bool, error) {
+	historyFile, err := historyFilename(filename)
+	if err != nil {
+		return false, err
+	}
+	f, err := os.Open(historyFile)
+	if err != nil {
+		// No undo history for this file
+		return false, nil
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return false, err
+	}
+	var h savedHistory
+	if err := json.NewDecoder(gz).Decode(&h); err != nil {
+		return false, err
+	}
+	hash, err := fileHash(filename)
+	if err != nil {
+		return false, err
+	}
+	if hash != h.Hash {
+		// The file was changed outside of this editor, so the undo history no longer applies
+		return false, os.Remove(historyFile)
+	}
+	if h.Layers != e.layers.Len() || h.Frames != e.frames.Len() {
+		// The steps were recorded with other layers and frames than the ones that were loaded,
+		// so they would be applied to the wrong pixels
+		return false, os.Remove(historyFile)
+	}
+
+	steps, err := loadSteps(h.Steps)
+	if err != nil {
+		return false, err
+	}
+	current, ok := steps[h.Current]
+	if !ok || len(h.Steps) == 0 {
+		return false, errors.New("the undo history is inconsistent")
+	}
+
+	u.mut.Lock()
+	defer u.mut.Unlock()
+	u.root = steps[h.Steps[0].ID]
+	u.current = current
+	u.nextID = h.NextID
+	u.used = 0
+	for _, step := range steps {
+		if step != u.root {
+			step.memoryCounted = step.memory()
+			u.used += step.memoryCounted
+		}
+	}
+	u.forget()
+	return len(steps) > 1, nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// useHistoryDir makes the undo history files go to a temporary directory, and returns a function that undoes this
+func useHistoryDir(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "favicon-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, hadOld := os.LookupEnv("XDG_STATE_HOME")
+	os.Setenv("XDG_STATE_HOME", dir)
+	return func() {
+		if hadOld {
+			os.Setenv("XDG_STATE_HOME", old)
+		} else {
+			os.Unsetenv("XDG_STATE_HOME")
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+// describeStates returns the id, parent, name and description of every state, for comparisons
+func describeStates(u *Undo) []string {
+	var descriptions []string
+	for _, s := range u.States() {
+		parent := -1
+		if s.parent != nil {
+			parent = s.parent.id
+		}
+		descriptions = append(descriptions, fmt.Sprintf("%d %d %s %s", s.id, parent, s.name, s))
+	}
+	return descriptions
+}
+
+func TestHistoryRoundTrip(t *testing.T) {
+	defer useHistoryDir(t)()
+	dir, err := ioutil.TempDir("", "favicon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	e := newTestEditor(16, 16, 7)
+	e.filename = filepath.Join(dir, "favicon.ico")
+	u := NewUndo(1 << 24)
+	states := recordEdits(t, u, e)
+	u.Checkpoint("done")
+	runCommand(t, u, e, "entry")
+	runCommand(t, u, e, "scale 32x32 entry")
+	states = append(states, editorState(e))
+	entries := e.entrySizes()
+	entryPixels := pixelsText(e.entries[0])
+	if err := e.Save(&e.filename, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.SaveHistory(e, e.filename); err != nil {
+		t.Fatal(err)
+	}
+
+	u2 := NewUndo(1 << 24)
+	loaded, err := u2.LoadHistory(e, e.filename)
+	if err != nil || !loaded {
+		t.Fatalf("expected the undo history to be loaded, got %v, %v", loaded, err)
+	}
+	expected, got := describeStates(u), describeStates(u2)
+	if len(got) != len(expected) {
+		t.Fatalf("expected the states %q, got %q", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("expected the state %q, got %q", expected[i], got[i])
+		}
+	}
+	if u2.Current().id != u.Current().id {
+		t.Errorf("expected the current state to be %d, got %d", u.Current().id, u2.Current().id)
+	}
+
+	// Undo everything with the loaded history
+	if _, err := u2.Restore(e); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.entries) != 0 {
+		t.Errorf("expected no entries after undoing, got %s", e.entrySizes())
+	}
+	for i := len(states) - 3; i >= 0; i-- {
+		if _, err := u2.Restore(e); err != nil {
+			t.Fatal(err)
+		}
+		if got := editorState(e); got != states[i] {
+			t.Fatalf("after undoing to state %d, expected:\n%s\ngot:\n%s", i, states[i], got)
+		}
+	}
+	u2.JumpTo(e, u2.LastCheckpoint())
+	if got := editorState(e); got != states[len(states)-2] {
+		t.Fatalf("after jumping to the checkpoint, expected:\n%s\ngot:\n%s", states[len(states)-2], got)
+	}
+	u2.JumpTo(e, u2.States()[len(expected)-1])
+	if e.entrySizes() != entries || pixelsText(e.entries[0]) != entryPixels {
+		t.Errorf("expected the entries %q to be restored, got %q", entries, e.entrySizes())
+	}
+}
+
+func TestHistoryChangedFile(t *testing.T) {
+	defer useHistoryDir(t)()
+	dir, err := ioutil.TempDir("", "favicon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	e := newTestEditor(16, 16, 7)
+	e.filename = filepath.Join(dir, "favicon.ico")
+	u := NewUndo(1 << 24)
+	paintAt(u, e, 3, 3, 0)
+	if err := e.Save(&e.filename, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.SaveHistory(e, e.filename); err != nil {
+		t.Fatal(err)
+	}
+	historyFile, err := historyFilename(e.filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(historyFile); err != nil {
+		t.Fatal(err)
+	}
+
+	// Change the image outside of the editor
+	paintAt(u, e, 4, 4, 0)
+	if err := e.Save(&e.filename, false); err != nil {
+		t.Fatal(err)
+	}
+	u2 := NewUndo(1 << 24)
+	loaded, err := u2.LoadHistory(e, e.filename)
+	if err != nil || loaded {
+		t.Fatalf("expected the undo history to be rejected, got %v, %v", loaded, err)
+	}
+	if u2.Index() != 0 {
+		t.Errorf("expected no undo steps, got %d", u2.Index())
+	}
+	if _, err := os.Stat(historyFile); !os.IsNotExist(err) {
+		t.Errorf("expected the undo history file to be removed, got %v", err)
+	}
+}
+
+func TestHistoryLayers(t *testing.T) {
+	defer useHistoryDir(t)()
+	dir, err := ioutil.TempDir("", "favicon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	e := newTestEditor(16, 16, 7)
+	e.filename = filepath.Join(dir, "favicon.png")
+	u := NewUndo(1 << 24)
+	states := []string{editorState(e)}
+	runCommand(t, u, e, "layer add top")
+	states = append(states, editorState(e))
+	paintAt(u, e, 4, 4, 0)
+	states = append(states, editorState(e))
+	if err := e.Save(&e.filename, false); err != nil {
+		t.Fatal(err)
+	}
+	save := func() {
+		if err := u.SaveHistory(e, e.filename); err != nil {
+			t.Fatal(err)
+		}
+	}
+	load := func() (*Editor, *Undo, bool) {
+		e2 := newTestEditor(1, 1, 0)
+		if _, err := e2.Load(nil, nil, e.filename); err != nil {
+			t.Fatal(err)
+		}
+		u2 := NewUndo(1 << 24)
+		loaded, err := u2.LoadHistory(e2, e.filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e2, u2, loaded
+	}
+	historyFile, err := historyFilename(e.filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The layers could not be loaded, so the steps would be applied to the flattened image
+	save()
+	if loaded, err := NewUndo(1<<24).LoadHistory(newTestEditor(16, 16, 7), e.filename); err != nil || loaded {
+		t.Fatalf("expected the undo history to be rejected for a flat image, got %v, %v", loaded, err)
+	}
+	if _, err := os.Stat(historyFile); !os.IsNotExist(err) {
+		t.Errorf("expected the undo history file to be removed, got %v", err)
+	}
+
+	// The layers are loaded before the undo history
+	save()
+	e2, u2, loaded := load()
+	if !loaded {
+		t.Fatal("expected the undo history to be loaded")
+	}
+	for i := len(states) - 2; i >= 0; i-- {
+		if _, err := u2.Restore(e2); err != nil {
+			t.Fatal(err)
+		}
+		if got := editorState(e2); got != states[i] {
+			t.Fatalf("after undoing to state %d, expected:\n%s\ngot:\n%s", i, states[i], got)
+		}
+	}
+
+	// The layers file was removed by another program
+	if err := os.Remove(LayersFilename(e.filename)); err != nil {
+		t.Fatal(err)
+	}
+	if e3, _, loaded := load(); loaded || !e3.Flat() {
+		t.Errorf("expected a flat image without an undo history, got %d layers and %v", e3.layers.Len(), loaded)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/xyproto/vt100"
+)
+
+const (
+	// thumbnailSize is the maximum width and height of the thumbnails in the history browser, in pixels
+	thumbnailSize = 16
+
+	// the size of each entry in the history browser, in characters
+	historyCellWidth  = 22
+	historyCellHeight = thumbnailSize/2 + 2
+)
+
+// Thumbnail returns a copy of the pixels, scaled down with nearest neighbour sampling to fit within size x size.
+// Pixels that already fit are returned as they are.
+func (p *Pixels) Thumbnail(size int) *Pixels {
+	if p.w <= size && p.h <= size {
+		return p
+	}
+	scale := p.w
+	if p.h > scale {
+		scale = p.h
+	}
+	tw, th := p.w*size/scale, p.h*size/scale
+	t := NewPixels(tw, th, transparent)
+	for y := 0; y < th; y++ {
+		for x := 0; x < tw; x++ {
+			t.Set(x, y, p.At(x*scale/size, y*scale/size))
+		}
+	}
+	return t
+}
+
+// drawHalfBlocks will draw the pixels onto the canvas at x,y, with two pixels per character, by using half blocks
+func drawHalfBlocks(c *vt100.Canvas, x, y int, p *Pixels) {
+	w, h := int(c.W()), int(c.H())
+	for py := 0; py < p.Height(); py += 2 {
+		for px := 0; px < p.Width(); px++ {
+			cx, cy := x+px, y+py/2
+			if cx < 0 || cy < 0 || cx >= w || cy >= h {
+				continue
+			}
+			c.WriteRuneB(uint(cx), uint(cy), valueColor(p.At(px, py)), valueBackground(p.At(px, py+1)), '▀')
+		}
+	}
+}
+
+// pixelsAt returns the image as it is at the given undo state, with all the visible layers
+func (e *Editor) pixelsAt(undo *Undo, state *undoStep) *Pixels {
+	e2 := *e
+	e2.lines, e2.width, e2.height = undo.LinesAt(e, state)
+	e2.layers = undo.LayersAt(e, state)
+	return e2.Composite()
+}
+
+// drawHistory will draw the history browser, with one thumbnail per state, and the selected state highlighted
+func (e *Editor) drawHistory(c *vt100.Canvas, undo *Undo, states []*undoStep, selected int) {
+	var (
+		w       = int(c.W())
+		h       = int(c.H())
+		columns = w / historyCellWidth
+		rows    = (h - 2) / historyCellHeight
+		current = undo.Current()
+	)
+	if columns < 1 {
+		columns = 1
+	}
+	if rows < 1 {
+		rows = 1
+	}
+	c.Clear()
+	c.Write(0, 0, e.fg, e.bg, "History: arrows to select, return to jump, n to name a checkpoint, c to compare with, esc to close")
+	// Show the page that contains the selected state
+	perPage := columns * rows
+	first := (selected / perPage) * perPage
+	for i := first; i < len(states) && i < first+perPage; i++ {
+		var (
+			state = states[i]
+			x     = ((i - first) % columns) * historyCellWidth
+			y     = 2 + ((i-first)/columns)*historyCellHeight
+		)
+		drawHalfBlocks(c, x, y, e.pixelsAt(undo, state).Thumbnail(thumbnailSize))
+		label := strconv.Itoa(state.id) + " " + state.String()
+		if state == current {
+			label = "*" + label
+		}
+		if len([]rune(label)) > historyCellWidth-1 {
+			label = string([]rune(label)[:historyCellWidth-1])
+		}
+		bg := e.bg
+		if i == selected {
+			bg = e.selectionBg
+		}
+		c.Write(uint(x), uint(y+thumbnailSize/2), e.fg, bg, label)
+	}
+	c.Draw()
+}
+
+// BrowseHistory shows all the states in the undo history as thumbnails, including the ones on other branches.
+// The user can jump to a state, name it as a checkpoint or pick it for A/B comparisons with the tab key.
+// Returns a status message.
+func (e *Editor) BrowseHistory(c *vt100.Canvas, tty *vt100.TTY, status *StatusBar, undo *Undo) string {
+	states := undo.States()
+	selected := 0
+	for i, state := range states {
+		if state == undo.Current() {
+			selected = i
+		}
+	}
+	columns := int(c.W()) / historyCellWidth
+	if columns < 1 {
+		columns = 1
+	}
+	msg := ""
+	for {
+		e.drawHistory(c, undo, states, selected)
+		switch tty.String() {
+		case "←":
+			if selected > 0 {
+				selected--
+			}
+		case "→":
+			if selected < len(states)-1 {
+				selected++
+			}
+		case "↑":
+			if selected-columns >= 0 {
+				selected -= columns
+			}
+		case "↓":
+			if selected+columns < len(states) {
+				selected += columns
+			}
+		case "c:13": // return, jump to the selected state
+			msg = "Jumped to " + undo.JumpTo(e, states[selected])
+			e.redraw = true
+			e.redrawCursor = true
+			return msg
+		case "n": // name the selected state
+			if name := status.ReadString(c, e, tty, "Checkpoint name:"); name != "" {
+				undo.SetName(states[selected], name)
+			}
+		case "c": // compare with the selected state
+			undo.SetCompare(states[selected])
+			msg = "Press tab to compare with " + states[selected].String()
+			e.redraw = true
+			return msg
+		case "c:27", "c:17", "c:20", "q": // esc, ctrl-q, ctrl-t or q
+			e.redraw = true
+			return msg
+		}
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"image/draw"
 	"image/png"
 	"io"
+	"io/ioutil"
 	"math"
 	"os"
 	"strings"
@@ -18,6 +19,9 @@ import (
 	ico "github.com/biessek/golang-ico"
 )
 
+// maxImageSize is the largest width and height that can be stored in an .ico file
+const maxImageSize = 256
+
 var (
 	// 4-bit, 16-color grayscale grading by runes
 	// This map has room for improvement.
@@ -47,7 +51,122 @@ var (
 	}
 )
 
-// ReadFavicon will try to load an ICO or PNG image into a "\n" separated []byte slice.
+// lumaValue converts a color, as returned by RGBA(), to a grayscale value from 0 to 15
+func lumaValue(r, g, b uint32) byte {
+	// Found a luma formula here: https://riptutorial.com/go/example/31693/convert-color-image-to-grayscale
+	luma := (0.2126*float64(r) + 0.7152*float64(g) + 0.0722*float64(b)) * (255.0 / 65535)
+	luma16 := int(math.Round(luma) / 16.0)
+	if luma16 > 15 {
+		luma16 = 15
+	}
+	return byte(luma16)
+}
+
+// ImagePixels converts an image to the pixel model, in the same way as ReadFavicon.
+// Fully transparent pixels are transparent.
+func ImagePixels(m image.Image) *Pixels {
+	b := m.Bounds()
+	p := NewPixels(b.Dx(), b.Dy(), transparent)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if r, g, bl, a := m.At(x, y).RGBA(); a != 0 {
+				p.Set(x-b.Min.X, y-b.Min.Y, lumaValue(r, g, bl))
+			}
+		}
+	}
+	return p
+}
+
+// readImage decodes an image file in any format that can be decoded, in full color
+func readImage(filename string) (image.Image, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m, _, err := image.Decode(f)
+	return m, err
+}
+
+// writePNG encodes the image as a PNG file, in full color
+func writePNG(filename string, m image.Image) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, m); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadEntries reads all the images in an .ico, .png or .fav file, as pixels.
+// .ico files can have several entries, while the other formats have one image.
+// For .fav files, this is the first frame, with the layers drawn on top of each other.
+func ReadEntries(filename string) ([]*Pixels, error) {
+	if strings.HasSuffix(filename, ".fav") {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		frames, err := ReadLayeredImage(data)
+		if err != nil {
+			return nil, err
+		}
+		first := frames.list[0].layers
+		w, h := first.Active().pixels.Width(), first.Active().pixels.Height()
+		return []*Pixels{first.flatten(w, h)}, nil
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var images []image.Image
+	if strings.HasSuffix(filename, ".ico") {
+		if images, err = ico.DecodeAll(f); err != nil {
+			return nil, err
+		}
+	} else {
+		m, err := png.Decode(f)
+		if err != nil {
+			return nil, err
+		}
+		images = []image.Image{m}
+	}
+	entries := make([]*Pixels, len(images))
+	for i, m := range images {
+		if m.Bounds().Dx() > maxImageSize || m.Bounds().Dy() > maxImageSize {
+			return nil, fmt.Errorf("can not load %s, the size is larger than %dx%d", filename, maxImageSize, maxImageSize)
+		}
+		entries[i] = ImagePixels(m)
+	}
+	return entries, nil
+}
+
+// WriteEntries writes the given images to an .ico, .png or .fav file.
+// Only .ico files can have several entries, the other formats get the first image.
+func WriteEntries(filename string, entries []*Pixels) error {
+	if len(entries) == 0 {
+		return errors.New("no images to write to " + filename)
+	}
+	if strings.HasSuffix(filename, ".fav") {
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "%s %dx%d active 1\n", layersHeader, entries[0].Width(), entries[0].Height())
+		layers := NewLayers("background")
+		layers.Active().pixels = entries[0]
+		writeLayers(&sb, layers)
+		return ioutil.WriteFile(filename, []byte(sb.String()), 0664)
+	}
+	if !strings.HasSuffix(filename, ".ico") && !strings.HasSuffix(filename, ".png") {
+		return errors.New(filename + " must be an .ico, .png or .fav file")
+	}
+	return WriteFavicon(modeGray4, pixelsText(entries[0]), filename, false, entries[1:]...)
+}
+
+// ReadFavicon will try to load an ICO or PNG image into a "\n" separated []byte slice, with one line per row of pixels.
+// The legend is not included, since it is drawn separately by the editor.
 // Returns a Mode (representing: 16 color grayscale, rgb or rgba), the textual representation and an error.
 // If blank is true, the textual representation of a blank 16 color grayscale image will be returned.
 // May return a warning/message string as well.
@@ -97,9 +216,8 @@ func ReadFavicon(filename string, blank, PNG bool) (Mode, []byte, string, error)
 	}
 
 	// Check the size of the image
-	// TODO: Consider lifting this restriction
-	if m.Bounds() != image.Rect(0, 0, 16, 16) {
-		return mode, []byte{}, "", errors.New("can not load " + filename + ", the size is not 16x16")
+	if m.Bounds().Dx() > maxImageSize || m.Bounds().Dy() > maxImageSize {
+		return mode, []byte{}, "", fmt.Errorf("can not load %s, the size is larger than %dx%d", filename, maxImageSize, maxImageSize)
 	}
 
 	lookupLetters := make(map[byte]rune)
@@ -116,21 +234,14 @@ func ReadFavicon(filename string, blank, PNG bool) (Mode, []byte, string, error)
 		}
 	}
 
-	var hasTransparentPixels bool
-
 	// Convert the image to a textual representation
 	bounds = m.Bounds()
 	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
 		for x := bounds.Min.X; x < bounds.Max.X; x++ {
 			r, g, b, a := m.At(x, y).RGBA()
-			// Found a luma formula here: https://riptutorial.com/go/example/31693/convert-color-image-to-grayscale
-			luma := (0.2126*float64(r) + 0.7152*float64(g) + 0.0722*float64(b)) * (255.0 / 65535)
 
 			// luma16 is 0..15
-			luma16 := int(math.Round(luma) / 16.0)
-			if luma16 > 15 {
-				luma16 = 15
-			}
+			luma16 := lumaValue(r, g, b)
 
 			mode = modeGray4 // 4-bit grayscale, 16 different color values
 
@@ -138,7 +249,6 @@ func ReadFavicon(filename string, blank, PNG bool) (Mode, []byte, string, error)
 				// 4-bit grayscale
 				if a == 0 {
 					buf.WriteString("T ") // transparent
-					hasTransparentPixels = true
 				} else if luma16 == 0 {
 					buf.WriteString("  ") // black
 				} else {
@@ -174,31 +284,42 @@ func ReadFavicon(filename string, blank, PNG bool) (Mode, []byte, string, error)
 			buf.WriteString("\n")
 		}
 	}
-	if mode == modeGray4 {
-		// Legend
-		buf.WriteString("\n")
-		for i := byte(0); i < byte(16); i++ {
-			buf.WriteString(fmt.Sprintf("%2d = %c\n", i, lookupLetters[i]))
-		}
-		if hasTransparentPixels {
-			buf.WriteString(" T = transparent, will be saved as black\n")
+	return mode, buf.Bytes(), message, nil
+}
+
+// textSize returns the size of the image in the given textual representation,
+// with one line per row of pixels and two runes per pixel
+func textSize(text string) (int, int) {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	width := 0
+	for _, line := range lines {
+		if w := (len([]rune(line)) + 1) / 2; w > width {
+			width = w
 		}
 	}
-	return mode, buf.Bytes(), message, nil
+	return width, len(lines)
 }
 
 // WriteFavicon converts the textual representation to an .ico image
-// If asOther is true, .png images are written as .ico and the other way around
-func WriteFavicon(mode Mode, text, filename string, asOther bool) error {
+// If asOther is true, .png images are written as .ico and the other way around.
+// Any other given entries are stored after the image in .ico files, and are left out of .png files.
+func WriteFavicon(mode Mode, text, filename string, asOther bool, entries ...*Pixels) error {
 	if mode != modeGray4 {
 		return errors.New("saving .ico files is only implemented for 4-bit grayscale images")
 	}
 
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	width, height := textSize(text)
+	if width > maxImageSize {
+		width = maxImageSize
+	}
+	if height > maxImageSize {
+		height = maxImageSize
+	}
+
 	var (
 		// Create a new image
-		width  = 16
-		height = 16
-		m      = image.NewRGBA(image.Rect(0, 0, width, height))
+		m = image.NewRGBA(image.Rect(0, 0, width, height))
 
 		// These are used in the loops below
 		x, y      int
@@ -209,12 +330,12 @@ func WriteFavicon(mode Mode, text, filename string, asOther bool) error {
 	)
 
 	// Draw the pixels
-	for y, line = range strings.Split(text, "\n") {
-		if y >= 16 { // max 16x16 pixels
+	for y, line = range lines {
+		if y >= height {
 			break
 		}
 		runes = []rune(line)
-		for x = 0; x < 16; x++ { // max 16x16 pixels
+		for x = 0; x < width; x++ {
 			if (x * 2) < len(runes) {
 				r = runes[x*2]
 				if r == 'T' { // transparent
@@ -258,9 +379,13 @@ func WriteFavicon(mode Mode, text, filename string, asOther bool) error {
 		return err
 	}
 
-	// Encode the image as an .ico image
+	// Encode the image as an .ico image, followed by the other entries
+	images := []image.Image{m}
+	for _, entry := range entries {
+		images = append(images, entry.Paletted())
+	}
 	//return ico.Encode(f, m)
-	return EncodeGrayscale4bit(f, m) // Sadly, this does not seem to support transparency
+	return EncodeGrayscale4bit(f, images...) // Sadly, this does not seem to support transparency
 }
 
 // This is from github.com/biessek/golang-ico, only to be able to use private structs
@@ -282,46 +407,60 @@ type direntry struct {
 	Offset  uint32
 }
 
-// EncodeGrayscale4bit is a modified version of the function from github.com/biessek/golang-ico, only to be able to save 4-bit .ico images
-func EncodeGrayscale4bit(w io.Writer, im image.Image) error {
-	b := im.Bounds()
-	m := image.NewGray(b)
-	draw.Draw(m, b, im, b.Min, draw.Src)
+// EncodeGrayscale4bit is a modified version of the function from github.com/biessek/golang-ico, only to be able to save 4-bit .ico images.
+// Each given image is stored as one entry in the .ico file, in the same order.
+func EncodeGrayscale4bit(w io.Writer, images ...image.Image) error {
 	header := head{
 		0,
 		1,
-		1,
-	}
-	entry := direntry{
-		Plane:  1,
-		Bits:   4, // was: 32
-		Offset: 22,
-	}
-	pngbuffer := new(bytes.Buffer)
-	pngwriter := bufio.NewWriter(pngbuffer)
-	err := png.Encode(pngwriter, m)
-	if err != nil {
-		return err
+		uint16(len(images)),
 	}
-	err = pngwriter.Flush()
-	if err != nil {
-		return err
+	var (
+		entries = make([]direntry, len(images))
+		pngs    = make([][]byte, len(images))
+		offset  = uint32(6 + 16*len(images)) // the image data comes after the header and the directory
+	)
+	for i, im := range images {
+		b := im.Bounds()
+		m := image.NewGray(b)
+		draw.Draw(m, b, im, b.Min, draw.Src)
+		pngbuffer := new(bytes.Buffer)
+		pngwriter := bufio.NewWriter(pngbuffer)
+		err := png.Encode(pngwriter, m)
+		if err != nil {
+			return err
+		}
+		err = pngwriter.Flush()
+		if err != nil {
+			return err
+		}
+		pngs[i] = pngbuffer.Bytes()
+		bounds := m.Bounds()
+		entries[i] = direntry{
+			Width:  uint8(bounds.Dx()), // 256 is stored as 0
+			Height: uint8(bounds.Dy()),
+			Plane:  1,
+			Bits:   4, // was: 32
+			Size:   uint32(len(pngs[i])),
+			Offset: offset,
+		}
+		offset += entries[i].Size
 	}
-	entry.Size = uint32(len(pngbuffer.Bytes()))
-	bounds := m.Bounds()
-	entry.Width = uint8(bounds.Dx())
-	entry.Height = uint8(bounds.Dy())
 	bb := new(bytes.Buffer)
 	var e error
 	if e = binary.Write(bb, binary.LittleEndian, header); e != nil {
 		return e
 	}
-	if e = binary.Write(bb, binary.LittleEndian, entry); e != nil {
+	if e = binary.Write(bb, binary.LittleEndian, entries); e != nil {
 		return e
 	}
 	if _, e = w.Write(bb.Bytes()); e != nil {
 		return e
 	}
-	_, e = w.Write(pngbuffer.Bytes())
-	return e
+	for _, data := range pngs {
+		if _, e = w.Write(data); e != nil {
+			return e
+		}
+	}
+	return nil
 }
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/xyproto/vt100"
+)
+
+// Layer is one of the images that are drawn on top of each other to make up the final image
+type Layer struct {
+	name    string
+	visible bool
+	opacity int     // 0..100 percent
+	pixels  *Pixels // the pixels of the layer, or nil for the active layer, which is in Editor.lines
+}
+
+// Layers is a stack of layers, the bottom one first
+type Layers struct {
+	stack  []*Layer
+	active int // the index of the layer that is being edited
+}
+
+// NewLayers creates a layer stack with a single visible layer with the given name
+func NewLayers(name string) *Layers {
+	return &Layers{stack: []*Layer{{name: name, visible: true, opacity: 100}}}
+}
+
+// Len returns the number of layers
+func (ls *Layers) Len() int {
+	return len(ls.stack)
+}
+
+// Active returns the layer that is being edited
+func (ls *Layers) Active() *Layer {
+	return ls.stack[ls.active]
+}
+
+// Copy returns a deep copy of the layer stack
+func (ls *Layers) Copy() *Layers {
+	ls2 := &Layers{stack: make([]*Layer, len(ls.stack)), active: ls.active}
+	for i, l := range ls.stack {
+		l2 := *l
+		if l.pixels != nil {
+			l2.pixels = l.pixels.Copy()
+		}
+		ls2.stack[i] = &l2
+	}
+	return ls2
+}
+
+// share returns a copy of the layer stack that shares the pixels with this one, which is cheaper than Copy.
+// This works because the pixels of a layer are replaced, and not changed, when the layer is edited.
+func (ls *Layers) share() *Layers {
+	ls2 := &Layers{stack: make([]*Layer, len(ls.stack)), active: ls.active}
+	for i, l := range ls.stack {
+		l2 := *l
+		ls2.stack[i] = &l2
+	}
+	return ls2
+}
+
+// Equal returns true if the two layer stacks are the same, not counting the pixels of the active layer
+func (ls *Layers) Equal(other *Layers) bool {
+	if ls.active != other.active || len(ls.stack) != len(other.stack) {
+		return false
+	}
+	for i, l := range ls.stack {
+		o := other.stack[i]
+		if l.name != o.name || l.visible != o.visible || l.opacity != o.opacity || (l.pixels == nil) != (o.pixels == nil) {
+			return false
+		}
+		if l.pixels != nil && l.pixels != o.pixels && (l.pixels.w != o.pixels.w || !bytes.Equal(l.pixels.values, o.pixels.values)) {
+			return false
+		}
+	}
+	return true
+}
+
+// memory returns the approximate number of bytes used by the layer stack
+func (ls *Layers) memory() int {
+	const overhead = 64 // for the structs and the slice headers
+	n := overhead
+	for _, l := range ls.stack {
+		n += overhead + len(l.name)
+		if l.pixels != nil {
+			n += len(l.pixels.values)
+		}
+	}
+	return n
+}
+
+// String returns a short description of the layer, like "badge (hidden, 50%)"
+func (l *Layer) String() string {
+	var props []string
+	if !l.visible {
+		props = append(props, "hidden")
+	}
+	if l.opacity < 100 {
+		props = append(props, strconv.Itoa(l.opacity)+"%")
+	}
+	if len(props) == 0 {
+		return l.name
+	}
+	return l.name + " (" + strings.Join(props, ", ") + ")"
+}
+
+// blend returns the value of a pixel with the src value drawn on top of the dst value, with the given opacity.
+// Since pixels are either transparent or opaque, a pixel that is drawn on top of a transparent one
+// is only kept if the opacity is at least 50%.
+func blend(dst, src byte, opacity int) byte {
+	switch {
+	case src == transparent:
+		return dst
+	case dst == transparent:
+		if opacity >= 50 {
+			return src
+		}
+		return transparent
+	}
+	return byte((int(dst)*(100-opacity) + int(src)*opacity + 50) / 100)
+}
+
+// pixelsText returns the textual representation of the given pixels, with one line per row of pixels
+func pixelsText(p *Pixels) string {
+	var sb strings.Builder
+	for y := 0; y < p.Height(); y++ {
+		if y > 0 {
+			sb.WriteRune('\n')
+		}
+		for x := 0; x < p.Width(); x++ {
+			sb.WriteRune(valueRune(p.At(x, y)))
+			sb.WriteRune(' ')
+		}
+	}
+	return sb.String()
+}
+
+// Composite returns the visible layers drawn on top of each other, which is what is saved as .ico or .png
+func (e *Editor) Composite() *Pixels {
+	if e.layers.Len() == 1 && e.layers.Active().visible && e.layers.Active().opacity == 100 {
+		return e.Pixels()
+	}
+	return e.AllLayers().flatten(e.width, e.height)
+}
+
+// AllLayers returns a copy of the layers, where the active layer also has its pixels set
+func (e *Editor) AllLayers() *Layers {
+	ls := e.layers.Copy()
+	ls.Active().pixels = e.Pixels()
+	return ls
+}
+
+// flatten returns the visible layers drawn on top of each other, as a w x h image.
+// All the layers must have their pixels set.
+func (ls *Layers) flatten(w, h int) *Pixels {
+	p := NewPixels(w, h, transparent)
+	for _, l := range ls.stack {
+		if !l.visible {
+			continue
+		}
+		for y := 0; y < p.h; y++ {
+			for x := 0; x < p.w; x++ {
+				p.Set(x, y, blend(p.At(x, y), l.pixels.At(x, y), l.opacity))
+			}
+		}
+	}
+	return p
+}
+
+// SelectLayer will make the layer with the given index the one that is being edited
+func (e *Editor) SelectLayer(i int) error {
+	if i < 0 || i >= e.layers.Len() {
+		return fmt.Errorf("there is no layer %d", i+1)
+	}
+	if i == e.layers.active {
+		return nil
+	}
+	e.layers.Active().pixels = e.Pixels()
+	e.layers.active = i
+	e.SetPixels(e.layers.Active().pixels)
+	e.layers.Active().pixels = nil
+	e.redraw = true
+	return nil
+}
+
+// AddLayer will add a transparent layer above the active one, and select it
+func (e *Editor) AddLayer(name string) {
+	l := &Layer{name: name, visible: true, opacity: 100, pixels: NewPixels(e.width, e.height, transparent)}
+	i := e.layers.active + 1
+	e.layers.stack = append(e.layers.stack[:i], append([]*Layer{l}, e.layers.stack[i:]...)...)
+	e.SelectLayer(i)
+}
+
+// DeleteLayer will remove the active layer, and select the one below it
+func (e *Editor) DeleteLayer() error {
+	if e.layers.Len() == 1 {
+		return errors.New("can not delete the only layer")
+	}
+	i := e.layers.active
+	next := i - 1
+	if next < 0 {
+		next = 1
+	}
+	e.SelectLayer(next)
+	e.layers.stack = append(e.layers.stack[:i], e.layers.stack[i+1:]...)
+	if e.layers.active > i {
+		e.layers.active--
+	}
+	return nil
+}
+
+// MoveLayer will move the active layer up (towards the top) or down in the stack
+func (e *Editor) MoveLayer(up bool) error {
+	i := e.layers.active
+	j := i - 1
+	if up {
+		j = i + 1
+	}
+	if j < 0 {
+		return errors.New("the layer is already at the bottom")
+	}
+	if j >= e.layers.Len() {
+		return errors.New("the layer is already at the top")
+	}
+	e.layers.stack[i], e.layers.stack[j] = e.layers.stack[j], e.layers.stack[i]
+	e.layers.active = j
+	return nil
+}
+
+// drawLayers will draw the list of layers below the legend, with the top layer first.
+// The active layer is highlighted.
+func (e *Editor) drawLayers(c *vt100.Canvas, fromline, toline, cx, cy int) {
+	if e.layers.Len() < 2 {
+		return
+	}
+	var (
+		x     = cx + e.gridWidth() + legendGap
+		w     = int(c.W())
+		first = len(legendLines()) + 1
+	)
+	lines := []string{"Layers:"}
+	for i := e.layers.Len() - 1; i >= 0; i-- {
+		lines = append(lines, fmt.Sprintf("%2d %s", i+1, e.layers.stack[i]))
+	}
+	for i, line := range lines {
+		y := first + i
+		if y < fromline || y >= toline || x+len(line) >= w {
+			continue
+		}
+		bg := e.bg
+		if i > 0 && e.layers.Len()-i == e.layers.active {
+			bg = e.selectionBg
+		}
+		c.Write(uint(x), uint(cy+y-fromline), e.fg, bg, line)
+	}
+}
+
+// layerCommand adds, deletes, moves, hides, shows or selects layers, or sets the opacity of the active layer
+func layerCommand(e *Editor, args []string) (string, error) {
+	usage := errors.New("usage: layer add [name]|delete|up|down|hide|show|opacity PERCENT|select N")
+	if len(args) == 0 {
+		return "", usage
+	}
+	l := e.layers.Active()
+	switch strings.ToLower(args[0]) {
+	case "add", "new":
+		name := strings.Join(args[1:], " ")
+		if name == "" {
+			name = "layer " + strconv.Itoa(e.layers.Len()+1)
+		}
+		e.AddLayer(name)
+		return "Added layer " + name, nil
+	case "delete", "remove":
+		if err := e.DeleteLayer(); err != nil {
+			return "", err
+		}
+		return "Deleted layer " + l.name, nil
+	case "up", "down":
+		if err := e.MoveLayer(strings.ToLower(args[0]) == "up"); err != nil {
+			return "", err
+		}
+		return "Moved layer " + l.name + " " + args[0], nil
+	case "hide":
+		l.visible = false
+		return "Layer " + l.name + " is hidden", nil
+	case "show":
+		l.visible = true
+		return "Layer " + l.name + " is visible", nil
+	case "opacity":
+		if len(args) != 2 {
+			return "", usage
+		}
+		opacity, err := strconv.Atoi(strings.TrimSuffix(args[1], "%"))
+		if err != nil || opacity < 0 || opacity > 100 {
+			return "", errors.New("the opacity must be from 0 to 100")
+		}
+		l.opacity = opacity
+		return "Layer " + l.name + " has an opacity of " + strconv.Itoa(opacity) + "%", nil
+	case "select":
+		if len(args) != 2 {
+			return "", usage
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			return "", usage
+		}
+		if err := e.SelectLayer(n - 1); err != nil {
+			return "", err
+		}
+		return "Editing layer " + e.layers.Active().name, nil
+	}
+	return "", usage
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/xyproto/vt100"
+)
+
+// legendGap is the number of columns between the pixel grid and the legend
+const legendGap = 4
+
+// legendRune returns the glyph that is listed in the legend for the given pixel value.
+// This is the rune from the textual representation, except for black, which is listed as _ instead of a blank.
+func legendRune(v byte) rune {
+	if v == 0 {
+		return '_'
+	}
+	return valueRune(v)
+}
+
+// legendLines returns the lines of the legend, one per pixel value, followed by one for transparent pixels
+func legendLines() []string {
+	lines := make([]string, 0, 17)
+	for v := byte(0); v < 16; v++ {
+		lines = append(lines, fmt.Sprintf("%2d = %c", v, legendRune(v)))
+	}
+	return append(lines, " T = transparent")
+}
+
+// drawLegend will draw the legend as a read-only panel to the right of the pixel grid.
+// The line for the current brush value is highlighted.
+func (e *Editor) drawLegend(c *vt100.Canvas, fromline, toline, cx, cy int) {
+	var (
+		x     = cx + e.gridWidth() + legendGap
+		w     = int(c.W())
+		lines = legendLines()
+	)
+	for i, line := range lines {
+		if i < fromline || i >= toline || x+len(line) >= w {
+			continue
+		}
+		v := byte(i)
+		if i == 16 {
+			v = transparent
+		}
+		bg := e.bg
+		if v == e.brush {
+			bg = e.selectionBg
+		}
+		c.Write(uint(x), uint(cy+i-fromline), e.fg, bg, line)
+	}
+}
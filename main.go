@@ -31,6 +31,10 @@ func main() {
 
 		versionFlag = flag.Bool("version", false, "show version information")
 		helpFlag    = flag.Bool("help", false, "show simple help")
+		undoFlag    = flag.Int("undomem", 16, "memory budget for the undo history, in MiB")
+		zoomFlag    = flag.String("zoom", zoomLevels[defaultZoom].String(), "terminal cells per pixel: 1x1, 2x1, 4x2 or 6x3")
+		sizeFlag    = flag.String("size", "16x16", "the size of new images, up to 256x256")
+		fillFlag    = flag.String("fill", "7", "the value that new images are filled with, 0 to F or T for transparent")
 
 		statusDuration = 2700 * time.Millisecond
 
@@ -56,28 +60,96 @@ Hotkeys
 
 ctrl-q     to quit
 ctrl-s     to save
-ctrl-a     go to start of line, then start of text and then the previous line
-ctrl-e     go to end of line and then the next line
+ctrl-a     go to the first pixel of the row
+ctrl-e     go to the last pixel of the row
 ctrl-p     to scroll up 10 lines
 ctrl-n     to scroll down 10 lines or go to the next match if a search is active
-ctrl-k     to delete characters to the end of the line, then delete the line
-ctrl-g     to toggle filename/line/column/unicode/word count status display
-ctrl-d     to delete a single character
-ctrl-x     to cut the current line
-ctrl-c     to copy the current line
-ctrl-v     to paste the current line
-ctrl-u     to undo
-ctrl-l     to jump to a specific line
+ctrl-f     to find the pixels with a value, which are highlighted until esc is pressed
+ctrl-k     to erase the pixels to the end of the row
+ctrl-g     to toggle a status display with the pixel x,y, the value at the cursor, the mode and the image size
+ctrl-d     to erase the pixel at the cursor
+ctrl-x     to cut the current row of pixels
+ctrl-c     to copy the current row of pixels
+ctrl-v     to paste a row of pixels, from the cursor and to the right
+ctrl-u     to undo (consecutive pixels that are painted are undone as one step)
+ctrl-r     to redo
+ctrl-l     to jump to a specific line, or to a pixel if x,y is given
+ctrl-b     to start selecting pixels from the cursor, or to remove the selection
+ctrl-o     to run a command on the image or the selection:
+             flip h|v, rotate 90|180|270, shift left|right|up|down [pixels],
+             symmetry off|vertical|horizontal|both|rotational,
+             layer add [name]|delete|up|down|hide|show|opacity PERCENT|select N,
+             frame add|duplicate|delete|next|prev|select N|delay MS|onion|export gif|apng,
+             zoom in|out|1x1|2x1|4x2|6x3, trace FILENAME|on|off|opacity PERCENT,
+             canvas WxH [nw|n|ne|w|c|e|sw|s|se] [fill 0-F|T], crop, trim,
+             scale WxH|FACTORx [nearest|scale2x|epx|scale3x|smooth|box|majority] [entry],
+             entry [remove WxH],
+             invert, brightness N, contrast N, levels BLACK WHITE [GAMMA], posterize N,
+             filter blur|blur5|sharpen|edge|emboss|WEIGHTS [clamp|wrap] [dither],
+             outline [inner|outer] [4|8] [layer], shadow [DX DY] [layer], glow [RADIUS] [layer]
+             (these use the brush value), replace FROM TO [TOLERANCE],
+             gradient linear|radial|diamond FROM TO [horizontal|vertical|diagonal] [dither],
+             bucket [4|8], rectangle [outline] (these and p fill with the brush value or the pattern),
+             pattern off|checker|diagonal|bayer2 PERCENT|bayer4 PERCENT|capture NAME|NAME,
+             text [3x5|4x6|5x7|8x8] [at nw|n|ne|w|c|e|sw|s|se] [kern N] [layer] TEXT,
+             generate initials|identicon TEXT [shape circle|rounded|square] [value V] [background V] [sizes 16,32,48],
+             variant ENV[,ENV...] [badge [COLOR]] [banner [COLOR]] [tint [COLOR] [PERCENT]] [gray],
+             badge dot|COUNT|all [corner nw|ne|sw|se] [color COLOR] [outline COLOR|none]
+             (adjustments, filters, replace, gradient, text, variant and badge are shown on the image while typing)
+ctrl-w     to cycle through the symmetry drawing modes
+ctrl-t     to browse the undo history, with thumbnails:
+             arrows to select a state, return to jump to it, n to name it as a checkpoint,
+             c to pick it for comparisons and esc to close
+tab        to toggle between the image and the last checkpoint (or the state picked with c)
+0..9, a..f to set the brush value (also A..F)
+[ and ]    to make the brush value darker or lighter
+i          to pick the brush value from the pixel at the cursor
+p          to paint the pixel at the cursor with the brush value, or the pattern
 esc        to redraw the screen and clear the last search
-ctrl-space to export to the other image format
+ctrl-space to export to the other image format (.fav files are exported to .ico)
 ctrl-~     to save and quit + clear the terminal
 
 Set NO_COLOR=1 to disable colors.
+Set FAVICON_SIZES to a list like 16,32,64 to choose the sizes of generated icons.
+
+Use -undomem N to let the undo history use up to N MiB of memory (the default is 16).
+
+Use -zoom 1x1, -zoom 2x1, -zoom 4x2 or -zoom 6x3 to set the number of terminal cells per pixel (the default is 2x1).
+
+Use -size WxH and -fill 0..F or T to set the size and the initial value of new images (the default is 16x16, filled with 7).
+
+Subcommands, that run without the editor:
+
+adjust INPUT OUTPUT ADJUSTMENT [ARGUMENTS]
+           to apply a tonal adjustment, in full color if both files are .png
+replace INPUT OUTPUT FROM TO [TOLERANCE]
+           to replace a value, or an RRGGBB color in full color if both files are .png
+scale INPUT WxH|FACTORx [METHOD] [OUTPUT]
+           to scale the image to a new file, or to add it as a new entry in an .ico file if no OUTPUT is given
+text OUTPUT WxH [value V] [background V] [FONT] [at ANCHOR] [kern N] TEXT
+           to write an image with the given text, like "text ci.ico 16x16 CI"
+variant INPUT ENV[,ENV...] [EFFECTS]
+           to write variants like favicon-staging.ico, with a badge, banner, tint or gray, in full color for .png
+generate initials|identicon TEXT OUTPUT [OPTIONS]
+           to write generated initials or an identicon, in all the sizes from FAVICON_SIZES (16,32,48 by default)
+badge INPUT dot|COUNT|all [OPTIONS]
+           to write a notification badge, or every badge with all, like favicon-3.png and favicon-3.ico
 
 `)
 		return
 	}
 
+	// Run a subcommand without the TUI, like "scale favicon.ico 32x32"
+	if f, ok := subcommands[flag.Arg(0)]; ok {
+		msg, err := f(flag.Args()[1:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error: "+err.Error())
+			os.Exit(1)
+		}
+		fmt.Println(msg)
+		return
+	}
+
 	filename := flag.Arg(0)
 	if filename == "" {
 		fmt.Fprintln(os.Stderr, "Need a filename.")
@@ -97,6 +169,23 @@ Set NO_COLOR=1 to disable colors.
 
 	baseFilename := filepath.Base(filename)
 
+	zoomLevel, err := ParseZoom(*zoomFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error: "+err.Error())
+		os.Exit(1)
+	}
+
+	newWidth, newHeight, err := ParseSize(*sizeFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error: "+err.Error())
+		os.Exit(1)
+	}
+	newFill, err := ParseValue(*fillFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error: "+err.Error())
+		os.Exit(1)
+	}
+
 	// Initialize the terminal
 	tty, err := vt100.NewTTY()
 	if err != nil {
@@ -106,9 +195,9 @@ Set NO_COLOR=1 to disable colors.
 	defer tty.Close()
 	vt100.Init()
 
-	// Check that the file is an .ico or .png image
-	if !strings.HasSuffix(filename, ".png") && !strings.HasSuffix(filename, ".ico") {
-		quitError(tty, errors.New(filename+" must be an .ico or a .png file"))
+	// Check that the file is an .ico or .png image, or a layered .fav image
+	if !strings.HasSuffix(filename, ".png") && !strings.HasSuffix(filename, ".ico") && !strings.HasSuffix(filename, ".fav") {
+		quitError(tty, errors.New(filename+" must be an .ico, .png or .fav file"))
 	}
 
 	// Create a Canvas for drawing onto the terminal
@@ -117,6 +206,7 @@ Set NO_COLOR=1 to disable colors.
 
 	// scroll 10 lines at a time, no word wrap
 	e := NewEditor(defaultEditorForeground, defaultEditorBackground, true, 10, defaultEditorSearchHighlight, mode)
+	e.zoomLevel = zoomLevel
 
 	// Adjust the word wrap if the terminal is too narrow
 	w := int(c.Width())
@@ -147,7 +237,9 @@ Set NO_COLOR=1 to disable colors.
 	e.redrawCursor = true
 
 	// Use os.Stat to check if the file exists, and load the file if it does
-	if fileInfo, err := os.Stat(filename); err == nil {
+	fileInfo, err := os.Stat(filename)
+	loaded := err == nil
+	if loaded {
 
 		// TODO: Enter file-rename mode when opening a directory?
 		// Check if this is a directory
@@ -191,6 +283,10 @@ Set NO_COLOR=1 to disable colors.
 		// For .ico and .png
 		if newMode != modeBlank {
 			e.mode = newMode
+			// Use the size and fill value from the flags, instead of the 16x16 gray image
+			e.SetPixels(NewPixels(newWidth, newHeight, newFill))
+			e.changed = false
+			statusMessage += fmt.Sprintf(" (%dx%d)", newWidth, newHeight)
 		}
 
 		// Test save, to check if the file can be created and written, or not
@@ -208,8 +304,17 @@ Set NO_COLOR=1 to disable colors.
 
 	// The editing mode is decided at this point
 
-	// Undo buffer with room for 8192 actions
-	undo := NewUndo(8192)
+	// Undo history that may use up to the given number of MiB
+	undo := NewUndo(*undoFlag * 1024 * 1024)
+
+	// Restore the undo history from the last editing session, if the file is unchanged since then
+	if loaded {
+		if restored, err := undo.LoadHistory(e, filename); err != nil {
+			statusMessage += " (could not load the undo history: " + err.Error() + ")"
+		} else if restored {
+			statusMessage += " (undo history restored)"
+		}
+	}
 
 	// Resize handler
 	SetUpResizeHandler(c, e, status, tty)
@@ -221,26 +326,33 @@ Set NO_COLOR=1 to disable colors.
 
 	// Draw editor lines from line 0 to h onto the canvas at 0,0
 	e.DrawLines(c, false, false)
+	status.DrawBrush(c, e)
 
 	status.SetMessage(statusMessage)
 	status.Show(c, e)
 
 	if e.redrawCursor {
-		x := e.pos.ScreenX()
-		y := e.pos.ScreenY()
+		x, y := e.CursorScreen()
 		previousX = x
 		previousY = y
 		vt100.SetXY(uint(x), uint(y))
 		e.redrawCursor = false
 	}
 
-	var (
-		quit        bool
-		previousKey string
-	)
+	var quit bool
 
 	for !quit {
 		key := tty.String()
+		// Any key ends an A/B comparison
+		if e.comparing {
+			e.comparing = false
+			e.compareLines = nil
+			e.redraw = true
+			status.ClearAll(c)
+			if key == "c:9" { // tab toggles back
+				key = ""
+			}
+		}
 		switch key {
 		case "c:17": // ctrl-q, quit
 			quit = true
@@ -273,6 +385,20 @@ Set NO_COLOR=1 to disable colors.
 					status.Show(c, e)
 				}
 				break // from case
+			} else if strings.HasSuffix(baseFilename, ".fav") {
+				// Save the flattened layers as .ico
+				err := e.Save(&filename, true)
+				if err != nil {
+					statusMessage = err.Error()
+					status.ClearAll(c)
+					status.SetMessage(statusMessage)
+					status.Show(c, e)
+				} else {
+					status.ClearAll(c)
+					status.SetMessage("Saved " + strings.Replace(baseFilename, ".fav", ".ico", 1))
+					status.Show(c, e)
+				}
+				break // from case
 			}
 			// Building this file extension is not implemented yet.
 			status.ClearAll(c)
@@ -281,21 +407,25 @@ Set NO_COLOR=1 to disable colors.
 			status.SetMessage(statusMessage)
 			status.Show(c, e)
 		case "←": // left arrow
-			// Draw mode
-			e.pos.Left()
-			e.redrawCursor = true
+			// Move one pixel to the left
+			e.MovePixel(-1, 0)
 		case "→": // right arrow
-			// Draw mode
-			e.pos.Right(c)
-			e.redrawCursor = true
+			// Move one pixel to the right
+			e.MovePixel(1, 0)
 		case "↑": // up arrow
-			// Move the screen cursor
-			e.pos.Up()
-			e.redrawCursor = true
+			// Move one pixel up
+			e.MovePixel(0, -1)
 		case "↓": // down arrow
-			e.pos.Down(c)
-			e.redrawCursor = true
+			// Move one pixel down
+			e.MovePixel(0, 1)
 		case "c:14": // ctrl-n, scroll down or jump to next match
+			if e.searching {
+				status.ClearAll(c)
+				status.SetMessage(e.FindNextMessage())
+				status.Show(c, e)
+				e.redraw = true
+				break // from case
+			}
 			// Scroll down
 			e.redraw = e.ScrollDown(c, status, e.pos.scrollSpeed)
 			// If e.redraw is false, the end of file is reached
@@ -309,86 +439,73 @@ Set NO_COLOR=1 to disable colors.
 			e.redraw = e.ScrollUp(c, status, e.pos.scrollSpeed)
 			e.redrawCursor = true
 		case "c:27": // esc, clear search term, reset, clean and redraw
+			e.ClearSearch()
 			c = e.FullResetRedraw(c, status)
+		case "c:6": // ctrl-f, find pixels with a value
+			value := status.ReadString(c, e, tty, "Find value (0-F or T):")
+			if value == "" {
+				break // from case
+			}
+			msg, err := e.SetSearch(value)
+			status.ClearAll(c)
+			if err != nil {
+				status.SetErrorMessage(err.Error())
+			} else {
+				status.SetMessage(msg)
+			}
+			status.Show(c, e)
 		case " ": // space
-			undo.Snapshot(e)
+			undo.Snapshot(e, "paint")
 			// Place a space
-			e.SetRune(' ')
+			e.Paint(' ')
 			e.WriteRune(c)
 			e.redraw = true
 		case "c:13": // return
-			undo.Snapshot(e)
-			// if the current line is empty, insert a blank line
-			if e.AtLastLineOfDocument() {
-				e.CreateLineIfMissing(e.DataY() + 1)
-			}
-			e.pos.Down(c)
-			e.redraw = true
+			// Go to the first pixel of the next row
+			e.GoToPixel(0, e.CursorPixel().Y+1)
 		case "c:8", "c:127": // ctrl-h or backspace
-			undo.Snapshot(e)
-			// Move back
-			e.Prev(c)
+			undo.Snapshot(e, "erase")
+			// Move back one pixel
+			e.MovePixel(-1, 0)
 			// Type a blank
-			e.SetRune(' ')
+			e.Paint(' ')
 			e.WriteRune(c)
 			e.redrawCursor = true
 			e.redraw = true
 		case "c:1", "c:25": // ctrl-a, home (or ctrl-y for scrolling up in the st terminal)
-			// First check if we just moved to this line with the arrow keys
-			justMovedUpOrDown := previousKey == "↓" || previousKey == "↑"
-			// If at an empty line, go up one line
-			if !justMovedUpOrDown && e.EmptyRightTrimmedLine() {
-				e.Up(c, status)
-				//e.GoToStartOfTextLine()
-				e.End()
-			} else if x, err := e.DataX(); err == nil && x == 0 && !justMovedUpOrDown {
-				// If at the start of the line,
-				// go to the end of the previous line
-				e.Up(c, status)
-				e.End()
-			} else if e.AtStartOfTextLine() {
-				// If at the start of the text, go to the start of the line
-				e.Home()
-			} else {
-				// If none of the above, go to the start of the text
-				e.GoToStartOfTextLine()
-			}
-			e.redrawCursor = true
+			// Go to the first pixel of the row
+			e.GoToPixel(0, e.CursorPixel().Y)
 			e.SaveX(true)
 		case "c:5": // ctrl-e, end
-			// First check if we just moved to this line with the arrow keys
-			justMovedUpOrDown := previousKey == "↓" || previousKey == "↑"
-			// If we didn't just move here, and are at the end of the line,
-			// move down one line and to the end, if not,
-			// just move to the end.
-			if !justMovedUpOrDown && e.AfterEndOfLine() {
-				e.Down(c, status)
-				e.End()
-			} else {
-				e.End()
-			}
-			e.redrawCursor = true
+			// Go to the last pixel of the row
+			e.GoToPixel(e.width-1, e.CursorPixel().Y)
 			e.SaveX(true)
 		case "c:4": // ctrl-d, delete
-			undo.Snapshot(e)
-			if e.Empty() {
-				status.SetMessage("Empty")
-				status.Show(c, e)
-			} else {
-				e.Delete()
-				e.redraw = true
-			}
+			undo.Snapshot(e, "erase")
+			// Type a blank, without moving
+			e.Paint(' ')
+			e.WriteRune(c)
 			e.redrawCursor = true
+			e.redraw = true
 		case "c:30": // ctrl-~, save and quit + clear the terminal
 			clearOnQuit = true
 			quit = true
 			fallthrough
 		case "c:19": // ctrl-s, save
+			undo.EndGroup()
 			status.ClearAll(c)
 			// Save the file
 			if err := e.Save(&filename, false); err != nil {
 				status.SetMessage(err.Error())
 				status.Show(c, e)
+			} else if err := undo.SaveHistory(e, filename); err != nil {
+				status.SetMessage("Saved " + filename + " (could not store the undo history: " + err.Error() + ")")
+				status.Show(c, e)
+				c.Draw()
+			} else if !e.Flat() && !strings.HasSuffix(filename, ".fav") {
+				status.SetMessage("Saved " + filename + " and the layers and frames in " + LayersFilename(filename))
+				status.Show(c, e)
+				c.Draw()
 			} else {
 				// Status message
 				status.SetMessage("Saved " + filename)
@@ -396,34 +513,48 @@ Set NO_COLOR=1 to disable colors.
 				c.Draw()
 			}
 		case "c:21", "c:26": // ctrl-u or ctrl-z, undo (ctrl-z may background the application)
-			if err := undo.Restore(e); err == nil {
-				//c.Draw()
-				x := e.pos.ScreenX()
-				y := e.pos.ScreenY()
-				vt100.SetXY(uint(x), uint(y))
+			status.ClearAll(c)
+			if what, err := undo.Restore(e); err == nil {
 				e.redrawCursor = true
 				e.redraw = true
+				status.SetMessage("Undid " + what)
 			} else {
 				status.SetMessage("No more to undo")
-				status.Show(c, e)
 			}
-		case "c:12": // ctrl-l, go to line number
+			status.Show(c, e)
+		case "c:18": // ctrl-r, redo
+			status.ClearAll(c)
+			if what, err := undo.Redo(e); err == nil {
+				e.redrawCursor = true
+				e.redraw = true
+				status.SetMessage("Redid " + what)
+			} else {
+				status.SetMessage("No more to redo")
+			}
+			status.Show(c, e)
+		case "c:7": // ctrl-g, toggle the status display
+			statusMode = !statusMode
+			if !statusMode {
+				status.ClearAll(c)
+			}
+		case "c:12": // ctrl-l, go to line number or to pixel x,y
 			status.ClearAll(c)
-			status.SetMessage("Go to line number:")
+			status.SetMessage("Go to line number or pixel x,y:")
 			status.ShowNoTimeout(c, e)
 			lns := ""
 			doneCollectingDigits := false
 			for !doneCollectingDigits {
 				numkey := tty.String()
 				switch numkey {
-				case "0", "1", "2", "3", "4", "5", "6", "7", "8", "9": // 0 .. 9
+				case "0", "1", "2", "3", "4", "5", "6", "7", "8", "9", ",": // 0 .. 9 or a comma
 					lns += numkey // string('0' + (numkey - 48))
-					status.SetMessage("Go to line number: " + lns)
+					status.SetMessage("Go to line number or pixel x,y: " + lns)
 					status.ShowNoTimeout(c, e)
 				case "c:8", "c:127": // ctrl-h or backspace
 					if len(lns) > 0 {
 						lns = lns[:len(lns)-1]
-						status.SetMessage("Go to line number: " + lns)
+						status.ClearAll(c)
+						status.SetMessage("Go to line number or pixel x,y: " + lns)
 						status.ShowNoTimeout(c, e)
 					}
 				case "c:27", "c:17": // esc or ctrl-q
@@ -434,43 +565,110 @@ Set NO_COLOR=1 to disable colors.
 				}
 			}
 			status.ClearAll(c)
-			if lns != "" {
+			if xy := strings.SplitN(lns, ",", 2); len(xy) == 2 {
+				x, errX := strconv.Atoi(xy[0])
+				y, errY := strconv.Atoi(xy[1])
+				if errX == nil && errY == nil { // no error
+					e.GoToPixel(x, y)
+				}
+			} else if lns != "" {
 				if ln, err := strconv.Atoi(lns); err == nil { // no error
 					e.redraw = e.GoToLineNumber(ln, c, status, true)
 				}
 			}
 			e.redrawCursor = true
-		case "c:11": // ctrl-k, delete to end of line
-			undo.Snapshot(e)
-			if e.Empty() {
-				status.SetMessage("Empty")
+		case "c:2": // ctrl-b, start or remove the selection
+			e.ToggleMark()
+		case "c:23": // ctrl-w, cycle through the symmetry modes
+			e.symmetry = e.symmetry.Next()
+			e.redraw = true
+			status.ClearAll(c)
+			status.SetMessage("Symmetry: " + e.symmetry.String())
+			status.Show(c, e)
+		case "c:20": // ctrl-t, browse the undo history
+			undo.EndGroup()
+			msg := e.BrowseHistory(c, tty, status, undo)
+			c = e.FullResetRedraw(c, status)
+			status.ClearAll(c)
+			if msg != "" {
+				status.SetMessage(msg)
 				status.Show(c, e)
+			}
+		case "c:9": // tab, compare the image with a checkpoint
+			target := undo.CompareTarget()
+			if target == nil {
+				status.ClearAll(c)
+				status.SetMessage("No checkpoint to compare with (press ctrl-t and n to name one)")
+				status.Show(c, e)
+				break // from case
+			}
+			e.compareLines, _, _ = undo.LinesAt(e, target)
+			e.comparing = true
+			e.redraw = true
+			status.ClearAll(c)
+			status.SetMessage("Comparing with " + target.String() + " (press any key to go back)")
+			status.ShowNoTimeout(c, e)
+		case "c:15": // ctrl-o, run a command
+			cmd := status.ReadCommand(c, e, tty, "Command:")
+			if cmd == "" {
+				break // from case
+			}
+			undo.SnapshotCommand(e, cmd)
+			msg, err := e.RunCommand(cmd)
+			status.ClearAll(c)
+			if err != nil {
+				status.SetErrorMessage(err.Error())
 			} else {
-				e.DeleteRestOfLine()
-				vt100.Do("Erase End of Line")
+				status.SetMessage(msg)
+			}
+			status.Show(c, e)
+		case "0", "1", "2", "3", "4", "5", "6", "7", "8", "9", "a", "b", "c", "d", "e", "f", "A", "B", "C", "D", "E", "F": // set the brush value
+			e.SetBrush(key)
+			undo.EndGroup()
+			e.redraw = true
+		case "[": // darker brush
+			e.DarkerBrush()
+			undo.EndGroup()
+			e.redraw = true
+		case "]": // lighter brush
+			e.LighterBrush()
+			undo.EndGroup()
+			e.redraw = true
+		case "i": // eyedropper, pick the brush value from the pixel at the cursor
+			if e.PickBrush() {
+				undo.EndGroup()
 				e.redraw = true
+			} else {
+				status.SetMessage("Not at a pixel")
+				status.Show(c, e)
 			}
+		case "p": // paint with the brush value
+			undo.Snapshot(e, "paint")
+			e.PaintBrush()
+			e.WriteRune(c)
+			e.redrawCursor = true
+			e.redraw = true
+		case "c:11": // ctrl-k, erase to the end of the row
+			undo.Snapshot(e, "erase to end of row")
+			p := e.CursorPixel()
+			e.ErasePixels(p.X, e.width, p.Y)
 			e.redrawCursor = true
-		case "c:24": // ctrl-x, cut line
-			undo.Snapshot(e)
-			y := e.DataY()
-			copyLine = e.Line(y)
-			// Copy the line to the clipboard
+		case "c:24": // ctrl-x, cut the row of pixels
+			undo.Snapshot(e, "cut")
+			y := e.CursorPixel().Y
+			copyLine = e.PixelRow(y)
+			// Copy the row to the clipboard
 			_ = clipboard.WriteAll(copyLine)
-			e.DeleteLine(y)
+			e.ErasePixels(0, e.width, y)
 			e.redrawCursor = true
-			e.redraw = true
-		case "c:3": // ctrl-c, copy the stripped contents of the current line
-			trimmed := strings.TrimSpace(e.Line(e.DataY()))
-			if trimmed != "" {
-				copyLine = trimmed
-				// Copy the line to the clipboard
-				_ = clipboard.WriteAll(copyLine)
-			}
+		case "c:3": // ctrl-c, copy the row of pixels
+			copyLine = e.PixelRow(e.CursorPixel().Y)
+			// Copy the row to the clipboard
+			_ = clipboard.WriteAll(copyLine)
 			e.redrawCursor = true
 			e.redraw = true
 		case "c:22": // ctrl-v, paste
-			undo.Snapshot(e)
+			undo.Snapshot(e, "paste")
 			// Try fetching the line from the clipboard first
 			lines, err := clipboard.ReadAll()
 			if err == nil { // no error
@@ -482,55 +680,44 @@ Set NO_COLOR=1 to disable colors.
 			}
 			// Fix nonbreaking spaces
 			copyLine = strings.Replace(copyLine, string([]byte{0xc2, 0xa0}), string([]byte{0x20}), -1)
-			if e.EmptyRightTrimmedLine() {
-				// If the line is empty, use the existing indentation before pasting
-				e.SetLine(e.DataY(), e.LeadingWhitespace()+strings.TrimSpace(copyLine))
-			} else {
-				// If the line is not empty, insert the trimmed string
-				e.InsertString(c, strings.TrimSpace(copyLine))
-			}
-			// Prepare to redraw the text
+			// Write the pixels over the ones from the cursor and to the right
+			e.PastePixels(copyLine)
 			e.redrawCursor = true
-			e.redraw = true
 		default:
 			if len([]rune(key)) > 0 && unicode.IsLetter([]rune(key)[0]) { // letter
-				undo.Snapshot(e)
+				undo.Snapshot(e, "paint")
 				// Type the letter that was pressed
 				if len([]rune(key)) > 0 {
 					// Replace this letter.
-					e.SetRune([]rune(key)[0])
+					e.Paint([]rune(key)[0])
 					e.WriteRune(c)
 					e.redraw = true
 				}
 			} else if len([]rune(key)) > 0 && unicode.IsGraphic([]rune(key)[0]) { // any other key that can be drawn
-				undo.Snapshot(e)
-
+				undo.Snapshot(e, "paint")
 				// Place *something*
-				r := []rune(key)[0]
-
-				// "smart dedent"
-				if r == '}' || r == ']' || r == ')' {
-					lineContents := strings.TrimSpace(e.CurrentLine())
-					whitespaceInFront := e.LeadingWhitespace()
-					if e.pos.sx > 0 && len(lineContents) == 0 && len(whitespaceInFront) > 0 {
-						// move one step left
-						e.Prev(c)
-						// trim trailing whitespace
-						e.TrimRight(e.DataY())
-					}
-				}
-
-				e.SetRune([]rune(key)[0])
+				e.Paint([]rune(key)[0])
 				e.WriteRune(c)
 				e.redrawCursor = true
 				e.redraw = true
 			}
 		}
-		previousKey = key
+		// Store the edit as an undo step, if anything was changed
+		undo.Commit(e)
+		// Redraw the selection if the cursor moved while selecting
+		if x, y := e.CursorScreen(); e.marked && (x != previousX || y != previousY) {
+			e.redraw = true
+		}
+		// Scroll the pixel grid if the cursor moved outside of it
+		if e.drawMode && e.ScrollToCursor(c) {
+			e.redraw = true
+		}
 		// Redraw, if needed
 		if e.redraw {
 			// Draw the editor lines on the canvas, respecting the offset
 			e.DrawLines(c, true, false)
+			status.DrawBrush(c, e)
+			c.Draw()
 			e.redraw = false
 		} else if e.Changed() {
 			c.Draw()
@@ -543,8 +730,7 @@ Set NO_COLOR=1 to disable colors.
 			status.Show(c, e)
 		}
 		// Position the cursor
-		x := e.pos.ScreenX()
-		y := e.pos.ScreenY()
+		x, y := e.CursorScreen()
 		if e.redrawCursor || x != previousX || y != previousY {
 			vt100.SetXY(uint(x), uint(y))
 			e.redrawCursor = false
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxTileSize is the largest width and height of a pattern tile that is captured from a selection
+const maxTileSize = 16
+
+// Pattern is a tile that is repeated over the image when filling with the bucket, rectangle and brush tools.
+// Transparent pixels in the tile leave the image as it is, so that patterns can be used for shading.
+type Pattern struct {
+	name  string
+	tile  *Pixels
+	brush bool // draw the pixels that are not transparent with the brush value, instead of their own value?
+}
+
+// bayer2 is a 2x2 ordered dither matrix, with thresholds from 0 to 3
+var bayer2 = [2][2]int{
+	{0, 2},
+	{3, 1},
+}
+
+// builtinPatterns are the names of the patterns that are always available.
+// The bayer patterns take a coverage from 0 to 100 percent.
+var builtinPatterns = []string{"checker", "diagonal", "bayer2", "bayer4"}
+
+// NewPattern returns the built-in pattern with the given name, like "checker", or "bayer4" with a coverage like 25.
+// The pixels that are set in a built-in pattern are drawn with the brush value.
+func NewPattern(name string, percent int) (*Pattern, error) {
+	var (
+		tile *Pixels
+		size int
+	)
+	switch name {
+	case "checker":
+		tile = NewPixels(2, 2, transparent)
+		tile.Set(0, 0, 0)
+		tile.Set(1, 1, 0)
+		return &Pattern{name, tile, true}, nil
+	case "diagonal":
+		tile = NewPixels(4, 4, transparent)
+		for i := 0; i < 4; i++ {
+			tile.Set(i, i, 0)
+		}
+		return &Pattern{name, tile, true}, nil
+	case "bayer2":
+		size = 2
+	case "bayer4":
+		size = 4
+	default:
+		return nil, errors.New("unknown pattern: " + name)
+	}
+	if percent < 0 || percent > 100 {
+		return nil, errors.New("the coverage must be from 0 to 100 percent")
+	}
+	tile = NewPixels(size, size, transparent)
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			threshold := bayer4[y][x]
+			if size == 2 {
+				threshold = bayer2[y][x]
+			}
+			// A pixel is set if its threshold is within the coverage, so that 50 sets half of the pixels
+			if (float64(threshold)+0.5)/float64(size*size) < float64(percent)/100 {
+				tile.Set(x, y, 0)
+			}
+		}
+	}
+	return &Pattern{fmt.Sprintf("%s %d%%", name, percent), tile, true}, nil
+}
+
+// At returns the value of the pattern at the given pixel in the image, where the tile is repeated from 0,0.
+// Returns transparent where the image should be left as it is.
+func (pat *Pattern) At(x, y int, brush byte) byte {
+	v := pat.tile.At(mod(x, pat.tile.w), mod(y, pat.tile.h))
+	if pat.brush && v != transparent {
+		return brush
+	}
+	return v
+}
+
+// fillValue returns the value to fill the pixel at x,y in the image with, which is the brush value,
+// or the value of the pattern if one is selected. Returns false if the pixel should be left as it is.
+func (e *Editor) fillValue(x, y int) (byte, bool) {
+	if e.pattern == nil {
+		return e.brush, true
+	}
+	v := e.pattern.At(x, y, e.brush)
+	return v, v != transparent
+}
+
+// fillPixels fills the given points in the pixels with the brush value or the pattern.
+// The pixels are at the given offset in the image, so that the pattern lines up with earlier fills.
+func (e *Editor) fillPixels(p *Pixels, points []image.Point, offset image.Point) {
+	for _, pt := range points {
+		if v, ok := e.fillValue(pt.X+offset.X, pt.Y+offset.Y); ok {
+			p.Set(pt.X, pt.Y, v)
+		}
+	}
+}
+
+// FloodArea returns the points of the area that has the same value as the pixel at x,y and is connected to it.
+// Pixels are connected if they share a side, or also a corner if connect8 is true.
+func (p *Pixels) FloodArea(x, y int, connect8 bool) []image.Point {
+	var (
+		v       = p.At(x, y)
+		visited = make([]bool, len(p.values))
+		stack   = []image.Point{{x, y}}
+		area    []image.Point
+	)
+	visited[y*p.w+x] = true
+	for len(stack) > 0 {
+		pt := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		area = append(area, pt)
+		for _, n := range neighbours(connect8) {
+			nx, ny := pt.X+n[0], pt.Y+n[1]
+			if nx < 0 || ny < 0 || nx >= p.w || ny >= p.h || visited[ny*p.w+nx] || p.At(nx, ny) != v {
+				continue
+			}
+			visited[ny*p.w+nx] = true
+			stack = append(stack, image.Pt(nx, ny))
+		}
+	}
+	return area
+}
+
+// selectionOffset returns the position of the selection in the image, or 0,0 if there is no selection
+func (e *Editor) selectionOffset() image.Point {
+	if sel, ok := e.Selection(); ok {
+		return sel.Min
+	}
+	return image.Point{}
+}
+
+// fillName returns "the brush value" or the name of the pattern, for status messages
+func (e *Editor) fillName() string {
+	if e.pattern == nil {
+		return "the brush value"
+	}
+	return "the " + e.pattern.name + " pattern"
+}
+
+// bucketCommand fills the area around the cursor that has the same value, within the selection if there is one,
+// with the brush value or the pattern, like "bucket 8"
+func bucketCommand(e *Editor, args []string) (string, error) {
+	usage := errors.New("usage: bucket [4|8]")
+	connect8 := false
+	switch {
+	case len(args) == 0:
+	case len(args) == 1 && args[0] == "4":
+	case len(args) == 1 && args[0] == "8":
+		connect8 = true
+	default:
+		return "", usage
+	}
+	if !e.AtPixel() {
+		return "", errors.New("not at a pixel")
+	}
+	var (
+		offset = e.selectionOffset()
+		cur    = e.CursorPixel().Sub(offset)
+	)
+	count := 0
+	err := e.Transform(func(p *Pixels) (*Pixels, error) {
+		if !cur.In(p.Bounds()) {
+			return nil, errors.New("the cursor must be within the selection")
+		}
+		area := p.FloodArea(cur.X, cur.Y, connect8)
+		count = len(area)
+		p2 := p.Copy()
+		e.fillPixels(p2, area, offset)
+		return p2, nil
+	})
+	return fmt.Sprintf("Filled %d pixels with %s", count, e.fillName()), err
+}
+
+// rectangleCommand fills the selection with the brush value or the pattern, or only draws its edge, like "rectangle outline"
+func rectangleCommand(e *Editor, args []string) (string, error) {
+	usage := errors.New("usage: rectangle [outline]")
+	outline := false
+	switch {
+	case len(args) == 0:
+	case len(args) == 1 && strings.ToLower(args[0]) == "outline":
+		outline = true
+	default:
+		return "", usage
+	}
+	if _, ok := e.Selection(); !ok {
+		return "", errors.New("select the rectangle first, with ctrl-b")
+	}
+	offset := e.selectionOffset()
+	return "Drew a rectangle with " + e.fillName(), e.Transform(func(p *Pixels) (*Pixels, error) {
+		var points []image.Point
+		for y := 0; y < p.h; y++ {
+			for x := 0; x < p.w; x++ {
+				if !outline || x == 0 || y == 0 || x == p.w-1 || y == p.h-1 {
+					points = append(points, image.Pt(x, y))
+				}
+			}
+		}
+		p2 := p.Copy()
+		e.fillPixels(p2, points, offset)
+		return p2, nil
+	})
+}
+
+// patternCommand selects the pattern to fill with, or captures the selection as a new pattern,
+// like "pattern checker", "pattern bayer4 25", "pattern capture bricks" or "pattern off"
+func patternCommand(e *Editor, args []string) (string, error) {
+	usage := errors.New("usage: pattern off|checker|diagonal|bayer2 PERCENT|bayer4 PERCENT|capture NAME|NAME")
+	if len(args) == 0 {
+		names := append([]string{}, builtinPatterns...)
+		custom := make([]string, 0, len(e.patterns))
+		for name := range e.patterns {
+			custom = append(custom, name)
+		}
+		sort.Strings(custom)
+		names = append(names, custom...)
+		return "Filling with " + e.fillName() + ". The patterns are: " + strings.Join(names, ", "), nil
+	}
+	name := strings.ToLower(args[0])
+	switch {
+	case (name == "off" || name == "solid") && len(args) == 1:
+		e.pattern = nil
+		return "Filling with the brush value", nil
+	case (name == "checker" || name == "diagonal") && len(args) == 1:
+		e.pattern, _ = NewPattern(name, 0)
+	case name == "bayer2" || name == "bayer4":
+		if len(args) != 2 {
+			return "", usage
+		}
+		percent, err := strconv.Atoi(strings.TrimSuffix(args[1], "%"))
+		if err != nil {
+			return "", usage
+		}
+		pat, err := NewPattern(name, percent)
+		if err != nil {
+			return "", err
+		}
+		e.pattern = pat
+	case name == "capture" && len(args) == 2:
+		name = strings.ToLower(args[1])
+		for _, builtin := range append([]string{"off", "solid", "capture"}, builtinPatterns...) {
+			if name == builtin {
+				return "", errors.New("there is already a built-in pattern named " + name)
+			}
+		}
+		sel, ok := e.Selection()
+		if !ok {
+			return "", errors.New("select the pixels to capture first, with ctrl-b")
+		}
+		if sel.Dx() > maxTileSize || sel.Dy() > maxTileSize {
+			return "", fmt.Errorf("a pattern can be at most %dx%d pixels", maxTileSize, maxTileSize)
+		}
+		if e.patterns == nil {
+			e.patterns = make(map[string]*Pattern)
+		}
+		e.patterns[name] = &Pattern{name, e.Pixels().Sub(sel), false}
+		e.pattern = e.patterns[name]
+		return fmt.Sprintf("Captured a %dx%d pattern named %s", sel.Dx(), sel.Dy(), name), nil
+	case len(args) == 1 && e.patterns[name] != nil:
+		e.pattern = e.patterns[name]
+	default:
+		return "", usage
+	}
+	return "Filling with " + e.fillName(), nil
+}
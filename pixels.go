@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"strconv"
+	"strings"
+
+	"github.com/xyproto/vt100"
+)
+
+// transparent is the pixel value that is used for transparent pixels, next to the 0..15 grayscale values
+const transparent byte = 16
+
+// Pixels is a grid of 4-bit grayscale values, where transparent pixels have the value "transparent".
+// This is the pixel model that image operations work on, as opposed to the textual representation.
+type Pixels struct {
+	w      int
+	h      int
+	values []byte
+}
+
+// NewPixels creates a new w x h grid of pixels, where all pixels have the given value
+func NewPixels(w, h int, fill byte) *Pixels {
+	p := &Pixels{w, h, make([]byte, w*h)}
+	for i := range p.values {
+		p.values[i] = fill
+	}
+	return p
+}
+
+// Width returns the width of the grid, in pixels
+func (p *Pixels) Width() int {
+	return p.w
+}
+
+// Height returns the height of the grid, in pixels
+func (p *Pixels) Height() int {
+	return p.h
+}
+
+// Bounds returns the size of the grid as a rectangle that starts at 0,0
+func (p *Pixels) Bounds() image.Rectangle {
+	return image.Rect(0, 0, p.w, p.h)
+}
+
+// At returns the pixel value at the given coordinates.
+// Pixels outside of the grid are transparent.
+func (p *Pixels) At(x, y int) byte {
+	if x < 0 || y < 0 || x >= p.w || y >= p.h {
+		return transparent
+	}
+	return p.values[y*p.w+x]
+}
+
+// Set will set the pixel value at the given coordinates, if they are within the grid
+func (p *Pixels) Set(x, y int, v byte) {
+	if x < 0 || y < 0 || x >= p.w || y >= p.h {
+		return
+	}
+	p.values[y*p.w+x] = v
+}
+
+// Copy returns a copy of the grid
+func (p *Pixels) Copy() *Pixels {
+	p2 := &Pixels{p.w, p.h, make([]byte, len(p.values))}
+	copy(p2.values, p.values)
+	return p2
+}
+
+// Sub returns a copy of the pixels within the given rectangle
+func (p *Pixels) Sub(r image.Rectangle) *Pixels {
+	r = r.Intersect(p.Bounds())
+	sub := NewPixels(r.Dx(), r.Dy(), transparent)
+	for y := 0; y < sub.h; y++ {
+		for x := 0; x < sub.w; x++ {
+			sub.Set(x, y, p.At(r.Min.X+x, r.Min.Y+y))
+		}
+	}
+	return sub
+}
+
+// Paste will draw the given pixels onto this grid, with the upper left corner at the given point
+func (p *Pixels) Paste(src *Pixels, at image.Point) {
+	for y := 0; y < src.h; y++ {
+		for x := 0; x < src.w; x++ {
+			p.Set(at.X+x, at.Y+y, src.At(x, y))
+		}
+	}
+}
+
+// valueRune returns the rune that represents the given pixel value in the textual representation
+func valueRune(v byte) rune {
+	switch v {
+	case 0:
+		// black, written as a blank, just like ReadFavicon does
+		return ' '
+	case transparent:
+		return 'T'
+	}
+	for r, lv := range lookupRunes {
+		if lv == v {
+			return r
+		}
+	}
+	return ' '
+}
+
+// runeValue returns the pixel value for the given rune from the textual representation.
+// Unknown runes are interpreted as black, just like WriteFavicon does.
+func runeValue(r rune) byte {
+	if r == 'T' {
+		return transparent
+	}
+	return lookupRunes[r]
+}
+
+// valueColor returns the terminal color that is the closest to the given pixel value
+func valueColor(v byte) vt100.AttributeColor {
+	switch {
+	case v == transparent:
+		return vt100.Default
+	case v < 4:
+		return vt100.Black
+	case v < 8:
+		return vt100.DarkGray
+	case v < 12:
+		return vt100.LightGray
+	}
+	return vt100.White
+}
+
+// valueName returns the pixel value as a hex digit, or "T" for transparent
+func valueName(v byte) string {
+	if v == transparent {
+		return "T"
+	}
+	return strings.ToUpper(strconv.FormatInt(int64(v), 16))
+}
+
+// ParseValue returns the pixel value for a hex digit, 0 to F, or "T" for transparent.
+// This is the opposite of valueName.
+func ParseValue(s string) (byte, error) {
+	if strings.ToUpper(s) == "T" {
+		return transparent, nil
+	}
+	v, err := strconv.ParseUint(s, 16, 8)
+	if err != nil || len(s) != 1 {
+		return 0, errors.New("the value must be a hex digit, 0 to F, or T for transparent")
+	}
+	return byte(v), nil
+}
+
+// valueBackground returns the terminal background color that is the closest to the given pixel value.
+// This also works for the light colors, which AttributeColor.Background does not convert.
+func valueBackground(v byte) vt100.AttributeColor {
+	fg := valueColor(v)
+	bg := make(vt100.AttributeColor, len(fg))
+	for i, attr := range fg {
+		if (30 <= attr && attr <= 39) || (90 <= attr && attr <= 97) {
+			attr += 10
+		}
+		bg[i] = attr
+	}
+	return bg
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// scaleMethods are the names of the scaling methods, for the usage messages
+const scaleMethods = "nearest|scale2x|epx|scale3x|smooth|box|majority"
+
+// clampAt returns the pixel value at the given coordinates, or at the closest edge pixel if outside of the grid
+func (p *Pixels) clampAt(x, y int) byte {
+	if x < 0 {
+		x = 0
+	} else if x >= p.w {
+		x = p.w - 1
+	}
+	if y < 0 {
+		y = 0
+	} else if y >= p.h {
+		y = p.h - 1
+	}
+	return p.At(x, y)
+}
+
+// ScaleNearest returns the pixels scaled to w x h, where each new pixel gets the value of the closest old pixel.
+// Scaling up by a whole number keeps all the pixels as crisp blocks.
+func (p *Pixels) ScaleNearest(w, h int) *Pixels {
+	p2 := NewPixels(w, h, transparent)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p2.Set(x, y, p.At(x*p.w/w, y*p.h/h))
+		}
+	}
+	return p2
+}
+
+// Scale2x returns the pixels scaled up to twice the size with the Scale2x algorithm, which is the same as EPX.
+// Each pixel becomes 2x2 pixels, where the corners take the value of the neighbours along diagonal edges.
+func (p *Pixels) Scale2x() *Pixels {
+	return p.scale2x(func(a, b byte) bool { return a == b }, func(edge, _, _ byte) byte { return edge })
+}
+
+// ScaleSmooth returns the pixels scaled up to twice the size, like Scale2x, but neighbours that are
+// one gray level apart are treated as equal, and the new corners are blended with the pixel, like hqx does
+func (p *Pixels) ScaleSmooth() *Pixels {
+	similar := func(a, b byte) bool {
+		if a == transparent || b == transparent {
+			return a == b
+		}
+		return int(a)-int(b) <= 1 && int(b)-int(a) <= 1
+	}
+	blend := func(edge, other, center byte) byte {
+		if edge == transparent || other == transparent || center == transparent {
+			return edge
+		}
+		return byte((int(edge) + int(other) + 2*int(center) + 2) / 4)
+	}
+	return p.scale2x(similar, blend)
+}
+
+// scale2x is the Scale2x algorithm, with a function for comparing two pixel values, and a function for
+// finding the value of a corner from the two neighbours along the edge and the pixel itself
+func (p *Pixels) scale2x(equal func(a, b byte) bool, corner func(edge, other, center byte) byte) *Pixels {
+	p2 := NewPixels(p.w*2, p.h*2, transparent)
+	for y := 0; y < p.h; y++ {
+		for x := 0; x < p.w; x++ {
+			var (
+				e = p.At(x, y)
+				a = p.clampAt(x, y-1) // above
+				b = p.clampAt(x+1, y) // right
+				c = p.clampAt(x-1, y) // left
+				d = p.clampAt(x, y+1) // below
+			)
+			e0, e1, e2, e3 := e, e, e, e
+			if equal(c, a) && !equal(c, d) && !equal(a, b) {
+				e0 = corner(a, c, e)
+			}
+			if equal(a, b) && !equal(a, c) && !equal(b, d) {
+				e1 = corner(b, a, e)
+			}
+			if equal(d, c) && !equal(d, b) && !equal(c, a) {
+				e2 = corner(c, d, e)
+			}
+			if equal(b, d) && !equal(b, a) && !equal(d, c) {
+				e3 = corner(d, b, e)
+			}
+			p2.Set(x*2, y*2, e0)
+			p2.Set(x*2+1, y*2, e1)
+			p2.Set(x*2, y*2+1, e2)
+			p2.Set(x*2+1, y*2+1, e3)
+		}
+	}
+	return p2
+}
+
+// Scale3x returns the pixels scaled up to three times the size with the Scale3x algorithm
+func (p *Pixels) Scale3x() *Pixels {
+	p2 := NewPixels(p.w*3, p.h*3, transparent)
+	for y := 0; y < p.h; y++ {
+		for x := 0; x < p.w; x++ {
+			var (
+				a, b, c = p.clampAt(x-1, y-1), p.clampAt(x, y-1), p.clampAt(x+1, y-1)
+				d, e, f = p.clampAt(x-1, y), p.At(x, y), p.clampAt(x+1, y)
+				g, h, i = p.clampAt(x-1, y+1), p.clampAt(x, y+1), p.clampAt(x+1, y+1)
+				out     = [9]byte{e, e, e, e, e, e, e, e, e}
+			)
+			if d == b && b != f && d != h {
+				out[0] = d
+			}
+			if (d == b && b != f && d != h && e != c) || (b == f && b != d && f != h && e != a) {
+				out[1] = b
+			}
+			if b == f && b != d && f != h {
+				out[2] = f
+			}
+			if (d == b && b != f && d != h && e != g) || (d == h && d != b && h != f && e != a) {
+				out[3] = d
+			}
+			if (b == f && b != d && f != h && e != i) || (h == f && d != h && b != f && e != c) {
+				out[5] = f
+			}
+			if d == h && d != b && h != f {
+				out[6] = d
+			}
+			if (d == h && d != b && h != f && e != i) || (h == f && d != h && b != f && e != g) {
+				out[7] = h
+			}
+			if h == f && d != h && b != f {
+				out[8] = f
+			}
+			for j, v := range out {
+				p2.Set(x*3+j%3, y*3+j/3, v)
+			}
+		}
+	}
+	return p2
+}
+
+// downscale returns the pixels scaled down to w x h, where the value of each new pixel is found by
+// the given function, from the values of all the old pixels that it covers
+func (p *Pixels) downscale(w, h int, f func(values []byte) byte) *Pixels {
+	p2 := NewPixels(w, h, transparent)
+	for y := 0; y < h; y++ {
+		y0, y1 := y*p.h/h, (y+1)*p.h/h
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < w; x++ {
+			x0, x1 := x*p.w/w, (x+1)*p.w/w
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+			var values []byte
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					values = append(values, p.At(sx, sy))
+				}
+			}
+			p2.Set(x, y, f(values))
+		}
+	}
+	return p2
+}
+
+// ScaleBox returns the pixels scaled down to w x h, where each new pixel is the average of the old pixels
+// that it covers. Pixels that are mostly transparent become transparent, just like Downscale.
+func (p *Pixels) ScaleBox(w, h int) *Pixels {
+	return p.downscale(w, h, func(values []byte) byte {
+		sum, n := 0, 0
+		for _, v := range values {
+			if v != transparent {
+				sum += int(v)
+				n++
+			}
+		}
+		if n*2 < len(values) {
+			return transparent
+		}
+		return byte((sum + n/2) / n)
+	})
+}
+
+// ScaleMajority returns the pixels scaled down to w x h, where each new pixel gets the most common value
+// of the old pixels that it covers. This keeps the original values, which suits pixel art with few colors.
+// If several values are equally common, the first one, from the upper left, is used.
+func (p *Pixels) ScaleMajority(w, h int) *Pixels {
+	return p.downscale(w, h, func(values []byte) byte {
+		var counts [transparent + 1]int
+		best := values[0]
+		for _, v := range values {
+			counts[v]++
+			if counts[v] > counts[best] {
+				best = v
+			}
+		}
+		return best
+	})
+}
+
+// Scale returns the pixels scaled to w x h with the given method, or with nearest neighbour when scaling up
+// and box averaging when scaling down, if no method is given.
+// The smoothing methods can only scale up, by 2, 3 or 4 times, which is done by scaling up twice by 2.
+func (p *Pixels) Scale(w, h int, method string) (*Pixels, error) {
+	up := w >= p.w && h >= p.h
+	if method == "" {
+		method = "box"
+		if up {
+			method = "nearest"
+		}
+	}
+	switch strings.ToLower(method) {
+	case "nearest":
+		return p.ScaleNearest(w, h), nil
+	case "box":
+		return p.ScaleBox(w, h), nil
+	case "majority":
+		return p.ScaleMajority(w, h), nil
+	}
+	var step func(*Pixels) *Pixels
+	switch strings.ToLower(method) {
+	case "scale2x", "epx":
+		step = (*Pixels).Scale2x
+	case "smooth":
+		step = (*Pixels).ScaleSmooth
+	case "scale3x":
+		if w != p.w*3 || h != p.h*3 {
+			return nil, errors.New("scale3x can only scale up by 3 times")
+		}
+		return p.Scale3x(), nil
+	default:
+		return nil, errors.New("the scaling method must be one of " + strings.Replace(scaleMethods, "|", ", ", -1))
+	}
+	switch {
+	case w == p.w*2 && h == p.h*2:
+		return step(p), nil
+	case w == p.w*3 && h == p.h*3:
+		return p.Scale3x(), nil
+	case w == p.w*4 && h == p.h*4:
+		return step(step(p)), nil
+	}
+	return nil, errors.New(method + " can only scale up by 2, 3 or 4 times")
+}
+
+// ParseScale parses the size to scale the given pixels to, either as WIDTHxHEIGHT, like "32x32",
+// or as a factor, like "2x" or "0.5x"
+func (p *Pixels) ParseScale(s string) (int, int, error) {
+	if strings.HasSuffix(s, "x") {
+		factor, err := strconv.ParseFloat(strings.TrimSuffix(s, "x"), 64)
+		if err != nil || factor <= 0 {
+			return 0, 0, errors.New("the scale factor must be a positive number, like 2x or 0.5x")
+		}
+		return ParseSize(fmt.Sprintf("%dx%d", int(float64(p.w)*factor+0.5), int(float64(p.h)*factor+0.5)))
+	}
+	return ParseSize(s)
+}
+
+// addEntry returns the entries with the given image added, sorted by width.
+// An entry with the same size is replaced.
+func addEntry(entries []*Pixels, p *Pixels) []*Pixels {
+	for i, entry := range entries {
+		if entry.Bounds() == p.Bounds() {
+			entries[i] = p
+			return entries
+		}
+	}
+	entries = append(entries, p)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Width() < entries[j].Width()
+	})
+	return entries
+}
+
+// AddEntry will add the given image as an entry in the .ico file, after the image that is being edited.
+// An entry with the same size is replaced.
+func (e *Editor) AddEntry(p *Pixels) {
+	e.entries = addEntry(e.entries, p)
+}
+
+// entrySizes returns the sizes of the other .ico entries, like "32x32, 48x48"
+func (e *Editor) entrySizes() string {
+	var sizes []string
+	for _, entry := range e.entries {
+		sizes = append(sizes, fmt.Sprintf("%dx%d", entry.Width(), entry.Height()))
+	}
+	return strings.Join(sizes, ", ")
+}
+
+// scaleCommand scales the image, like "scale 2x scale2x", or adds a scaled copy of it as a new .ico entry,
+// like "scale 32x32 nearest entry"
+func scaleCommand(e *Editor, args []string) (string, error) {
+	usage := errors.New("usage: scale WIDTHxHEIGHT|FACTORx [" + scaleMethods + "] [entry]")
+	if len(args) < 1 || len(args) > 3 {
+		return "", usage
+	}
+	var (
+		method string
+		entry  bool
+	)
+	for _, arg := range args[1:] {
+		if strings.ToLower(arg) == "entry" {
+			entry = true
+		} else if method == "" {
+			method = arg
+		} else {
+			return "", usage
+		}
+	}
+	p := e.Composite()
+	w, h, err := p.ParseScale(args[0])
+	if err != nil {
+		return "", err
+	}
+	if entry {
+		if !strings.HasSuffix(e.filename, ".ico") {
+			return "", errors.New("only .ico files can have several entries")
+		}
+		scaled, err := p.Scale(w, h, method)
+		if err != nil {
+			return "", err
+		}
+		e.AddEntry(scaled)
+		e.changed = true
+		return "The other entries are: " + e.entrySizes(), nil
+	}
+	// Check that the method can be used before scaling all the layers
+	if _, err := p.Scale(w, h, method); err != nil {
+		return "", err
+	}
+	e.ResizeCanvas(func(p *Pixels) *Pixels {
+		scaled, _ := p.Scale(w, h, method)
+		return scaled
+	})
+	return fmt.Sprintf("Scaled the image to %dx%d", w, h), nil
+}
+
+// entryCommand lists the other entries in the .ico file, or removes one of them, like "entry remove 32x32"
+func entryCommand(e *Editor, args []string) (string, error) {
+	switch {
+	case len(args) == 0:
+		if len(e.entries) == 0 {
+			return "There are no other entries", nil
+		}
+		return "The other entries are: " + e.entrySizes(), nil
+	case len(args) == 2 && strings.ToLower(args[0]) == "remove":
+		w, h, err := ParseSize(args[1])
+		if err != nil {
+			return "", err
+		}
+		for i, entry := range e.entries {
+			if entry.Width() == w && entry.Height() == h {
+				e.entries = append(e.entries[:i], e.entries[i+1:]...)
+				e.changed = true
+				return "Removed the " + args[1] + " entry", nil
+			}
+		}
+		return "", errors.New("there is no " + args[1] + " entry")
+	}
+	return "", errors.New("usage: entry [remove WIDTHxHEIGHT]")
+}
+
+// scaleSubcommand scales the first image in a file, like "scale favicon.ico 32x32 scale2x favicon32.png".
+// Without an output filename, the scaled image is added as a new entry in the given .ico file.
+func scaleSubcommand(args []string) (string, error) {
+	usage := errors.New("usage: scale INPUT WIDTHxHEIGHT|FACTORx [" + scaleMethods + "] [OUTPUT]")
+	if len(args) < 2 || len(args) > 4 {
+		return "", usage
+	}
+	var (
+		input       = args[0]
+		output      string
+		method      string
+		isImageFile = func(s string) bool {
+			return strings.HasSuffix(s, ".ico") || strings.HasSuffix(s, ".png") || strings.HasSuffix(s, ".fav")
+		}
+	)
+	for _, arg := range args[2:] {
+		if isImageFile(arg) && output == "" {
+			output = arg
+		} else if method == "" {
+			method = arg
+		} else {
+			return "", usage
+		}
+	}
+	entries, err := ReadEntries(input)
+	if err != nil {
+		return "", err
+	}
+	w, h, err := entries[0].ParseScale(args[1])
+	if err != nil {
+		return "", err
+	}
+	scaled, err := entries[0].Scale(w, h, method)
+	if err != nil {
+		return "", err
+	}
+	if output != "" {
+		if err := WriteEntries(output, []*Pixels{scaled}); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Wrote the image, scaled to %dx%d, to %s", w, h, output), nil
+	}
+	if !strings.HasSuffix(input, ".ico") {
+		return "", errors.New("give an output filename, only .ico files can have several entries")
+	}
+	// The image that is edited stays first, and any other entry with the same size is replaced
+	others := addEntry(append([]*Pixels{}, entries[1:]...), scaled)
+	if err := WriteEntries(input, append([]*Pixels{entries[0]}, others...)); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Added a %dx%d entry to %s", w, h, input), nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// Replace returns a copy of the pixels where every pixel with the value from, or a gray level that is
+// at most tolerance levels away from it, has the value to instead. Transparent pixels only match transparent.
+// Also returns the number of pixels that were replaced.
+func (p *Pixels) Replace(from, to byte, tolerance int) (*Pixels, int) {
+	p2 := p.Copy()
+	count := 0
+	for i, v := range p2.values {
+		if valueMatches(v, from, tolerance) && v != to {
+			p2.values[i] = to
+			count++
+		}
+	}
+	return p2, count
+}
+
+// valueMatches returns true if the pixel value v is the same as the wanted value, or a gray level
+// that is at most tolerance levels away from it
+func valueMatches(v, wanted byte, tolerance int) bool {
+	if v == transparent || wanted == transparent {
+		return v == wanted
+	}
+	diff := int(v) - int(wanted)
+	return -tolerance <= diff && diff <= tolerance
+}
+
+// FindNext will move the cursor to the next pixel with the value that is searched for, after the cursor,
+// row by row, and start over from the top when the end is reached. Returns the number of matches in the image.
+func (e *Editor) FindNext() int {
+	var (
+		p     = e.Pixels()
+		cur   = e.CursorPixel()
+		n     = p.w * p.h
+		start = cur.Y*p.w + cur.X
+		count = 0
+	)
+	for _, v := range p.values {
+		if v == e.searchValue {
+			count++
+		}
+	}
+	for i := 1; i <= n && count > 0; i++ {
+		j := (start + i) % n
+		if p.values[j] == e.searchValue {
+			e.GoToPixel(j%p.w, j/p.w)
+			break
+		}
+	}
+	return count
+}
+
+// SetSearch will start searching for pixels with the given value, like "5" or "T", and move the cursor to the next one.
+// Returns a status message.
+func (e *Editor) SetSearch(value string) (string, error) {
+	v, err := ParseValue(strings.TrimSpace(value))
+	if err != nil {
+		return "", err
+	}
+	e.searchValue = v
+	e.searching = true
+	e.redraw = true
+	return e.FindNextMessage(), nil
+}
+
+// FindNextMessage will move the cursor to the next match, and return a status message about the search
+func (e *Editor) FindNextMessage() string {
+	count := e.FindNext()
+	if count == 0 {
+		return "No pixels with the value " + valueName(e.searchValue)
+	}
+	p := e.CursorPixel()
+	return fmt.Sprintf("%d pixels with the value %s, at %d,%d (ctrl-n for the next one)", count, valueName(e.searchValue), p.X, p.Y)
+}
+
+// ClearSearch will stop highlighting the pixels that are searched for
+func (e *Editor) ClearSearch() {
+	e.searching = false
+}
+
+// searchRune returns the rune that is drawn for a pixel that matches the search.
+// Black pixels are blank, so a dot is drawn instead, to make the highlight visible.
+func searchRune(r rune) rune {
+	if r == ' ' {
+		return '·'
+	}
+	return r
+}
+
+// replaceCommand replaces one pixel value with another in the selection or in the whole image,
+// like "replace 5 a", or also the gray levels that are close to it, like "replace 5 a 1"
+func replaceCommand(e *Editor, args []string) (string, error) {
+	usage := errors.New("usage: replace FROM TO [TOLERANCE], where FROM and TO are 0-F or T")
+	if len(args) < 2 || len(args) > 3 {
+		return "", usage
+	}
+	from, err := ParseValue(args[0])
+	if err != nil {
+		return "", err
+	}
+	to, err := ParseValue(args[1])
+	if err != nil {
+		return "", err
+	}
+	tolerance := 0
+	if len(args) == 3 {
+		if tolerance, err = strconv.Atoi(args[2]); err != nil || tolerance < 0 || tolerance > 15 {
+			return "", errors.New("the tolerance must be from 0 to 15 gray levels")
+		}
+	}
+	count := 0
+	err = e.Transform(func(p *Pixels) (*Pixels, error) {
+		p2, n := p.Replace(from, to, tolerance)
+		count = n
+		return p2, nil
+	})
+	return fmt.Sprintf("Replaced %d pixels in the %s", count, e.where()), err
+}
+
+// parseColor parses a color given as RRGGBB or #RRGGBB, or T for transparent
+func parseColor(s string) (color.NRGBA, error) {
+	if strings.ToUpper(s) == "T" {
+		return color.NRGBA{}, nil
+	}
+	n, err := strconv.ParseUint(strings.TrimPrefix(s, "#"), 16, 32)
+	if err != nil || len(strings.TrimPrefix(s, "#")) != 6 {
+		return color.NRGBA{}, errors.New("the color must be given as RRGGBB, like ff8800, or as T for transparent")
+	}
+	return color.NRGBA{uint8(n >> 16), uint8(n >> 8), uint8(n), 0xff}, nil
+}
+
+// ReplaceColor returns a copy of the image in full color, where every pixel with the color from,
+// or a color where each of the red, green and blue channels are at most tolerance away from it,
+// has the color to instead. Fully transparent pixels only match a transparent color.
+// Also returns the number of pixels that were replaced.
+func ReplaceColor(m image.Image, from, to color.NRGBA, tolerance int) (*image.NRGBA, int) {
+	b := m.Bounds()
+	m2 := image.NewNRGBA(b)
+	count := 0
+	near := func(a, b uint8) bool {
+		diff := int(a) - int(b)
+		return -tolerance <= diff && diff <= tolerance
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			match := c.A == 0 && from.A == 0
+			if c.A != 0 && from.A != 0 {
+				match = near(c.R, from.R) && near(c.G, from.G) && near(c.B, from.B)
+			}
+			if match {
+				c = to
+				count++
+			}
+			m2.SetNRGBA(x, y, c)
+		}
+	}
+	return m2, count
+}
+
+// replaceSubcommand replaces one value or color with another in an image file, like "replace in.png out.png 5 a".
+// When both files are .png, the colors are given as RRGGBB, and the image is written in full color.
+func replaceSubcommand(args []string) (string, error) {
+	if len(args) < 4 || len(args) > 5 {
+		return "", errors.New("usage: replace INPUT OUTPUT FROM TO [TOLERANCE], with 0-F or T, or RRGGBB if both files are .png")
+	}
+	input, output := args[0], args[1]
+	tolerance := 0
+	if len(args) == 5 {
+		var err error
+		if tolerance, err = strconv.Atoi(args[4]); err != nil || tolerance < 0 || tolerance > 255 {
+			return "", errors.New("the tolerance must be from 0 to 15 gray levels, or from 0 to 255 for colors")
+		}
+	}
+	if strings.HasSuffix(input, ".png") && strings.HasSuffix(output, ".png") && len(args[2]) > 1 {
+		from, err := parseColor(args[2])
+		if err != nil {
+			return "", err
+		}
+		to, err := parseColor(args[3])
+		if err != nil {
+			return "", err
+		}
+		m, err := readImage(input)
+		if err != nil {
+			return "", err
+		}
+		m2, count := ReplaceColor(m, from, to, tolerance)
+		return fmt.Sprintf("Replaced %d pixels and wrote the image to %s", count, output), writePNG(output, m2)
+	}
+	from, err := ParseValue(args[2])
+	if err != nil {
+		return "", err
+	}
+	to, err := ParseValue(args[3])
+	if err != nil {
+		return "", err
+	}
+	entries, err := ReadEntries(input)
+	if err != nil {
+		return "", err
+	}
+	total := 0
+	for i, entry := range entries {
+		var count int
+		entries[i], count = entry.Replace(from, to, tolerance)
+		total += count
+	}
+	return fmt.Sprintf("Replaced %d pixels and wrote the image to %s", total, output), WriteEntries(output, entries)
+}
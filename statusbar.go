@@ -3,6 +3,7 @@ package main
 import (
 	"strconv"
 	"time"
+	"unicode"
 
 	"github.com/xyproto/vt100"
 )
@@ -62,6 +63,7 @@ func (sb *StatusBar) Clear(c *vt100.Canvas) {
 	e := sb.editor
 	// Write all lines to the buffer
 	e.WriteLines(c, e.pos.Offset(), h+e.pos.Offset(), 0, 0)
+	sb.DrawBrush(c, e)
 	c.Draw()
 	// Not an error message
 	sb.isError = false
@@ -120,3 +122,67 @@ func (sb *StatusBar) ShowLineColWordCount(c *vt100.Canvas, e *Editor, filename s
 	sb.SetMessage(statusString)
 	sb.ShowNoTimeout(c, e)
 }
+
+// DrawBrush will draw the current brush value in the lower left corner, as a hex digit, a glyph and a color swatch
+func (sb *StatusBar) DrawBrush(c *vt100.Canvas, e *Editor) {
+	if !e.drawMode {
+		return
+	}
+	label := "brush " + valueName(e.brush) + " " + string(valueRune(e.brush)) + " "
+	y := c.H() - 1
+	c.Write(0, y, sb.fg, sb.bg, label)
+	c.Write(uint(len([]rune(label))), y, valueColor(e.brush), e.bg, "██")
+	if e.pattern != nil {
+		c.Write(uint(len([]rune(label))+2), y, sb.fg, sb.bg, " "+e.pattern.name)
+	}
+}
+
+// ReadString will show the given prompt and then read a string from the keyboard, until return is pressed.
+// Returns an empty string if esc or ctrl-q is pressed.
+func (sb *StatusBar) ReadString(c *vt100.Canvas, e *Editor, tty *vt100.TTY, prompt string) string {
+	return sb.readString(c, e, tty, prompt, false)
+}
+
+// ReadCommand is like ReadString, but commands that can be previewed, like "posterize 4",
+// are shown on the image while they are being typed in. The preview is removed before returning.
+func (sb *StatusBar) ReadCommand(c *vt100.Canvas, e *Editor, tty *vt100.TTY, prompt string) string {
+	return sb.readString(c, e, tty, prompt, true)
+}
+
+// readString reads a string from the keyboard, for ReadString and ReadCommand
+func (sb *StatusBar) readString(c *vt100.Canvas, e *Editor, tty *vt100.TTY, prompt string, preview bool) string {
+	s := ""
+	sb.ClearAll(c)
+	sb.SetMessage(prompt)
+	sb.ShowNoTimeout(c, e)
+	for {
+		key := tty.String()
+		switch key {
+		case "c:8", "c:127": // ctrl-h or backspace
+			if len(s) > 0 {
+				s = string([]rune(s)[:len([]rune(s))-1])
+				sb.ClearAll(c)
+				sb.SetMessage(prompt + " " + s)
+				sb.ShowNoTimeout(c, e)
+			}
+		case "c:27", "c:17": // esc or ctrl-q
+			e.EndPreview()
+			sb.ClearAll(c)
+			return ""
+		case "c:13": // return
+			e.EndPreview()
+			sb.ClearAll(c)
+			return s
+		default:
+			if len([]rune(key)) == 1 && unicode.IsPrint([]rune(key)[0]) {
+				s += key
+				sb.SetMessage(prompt + " " + s)
+				sb.ShowNoTimeout(c, e)
+			}
+		}
+		if preview && e.Preview(s) {
+			e.DrawLines(c, true, false)
+			sb.ShowNoTimeout(c, e)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"strings"
+
+	"github.com/xyproto/vt100"
+)
+
+const (
+	// Symmetry "enum"
+	symmetryOff        = iota
+	symmetryVertical   // mirror across the vertical axis (left/right)
+	symmetryHorizontal // mirror across the horizontal axis (top/bottom)
+	symmetryBoth       // mirror across both axes (four-way)
+	symmetryRotational // rotate around the center of the image
+)
+
+// Symmetry is a drawing mode where every painted pixel is also painted at the mirrored positions
+type Symmetry int
+
+// symmetryNames are used for status messages and for the "symmetry" command
+var symmetryNames = map[Symmetry]string{
+	symmetryOff:        "off",
+	symmetryVertical:   "vertical",
+	symmetryHorizontal: "horizontal",
+	symmetryBoth:       "both",
+	symmetryRotational: "rotational",
+}
+
+// String returns the name of the symmetry mode
+func (s Symmetry) String() string {
+	return symmetryNames[s]
+}
+
+// Next returns the next symmetry mode, wrapping around after the last one
+func (s Symmetry) Next() Symmetry {
+	return (s + 1) % Symmetry(len(symmetryNames))
+}
+
+// Mirror returns the positions that mirror the given pixel position within a w x h image,
+// not including the given position itself.
+// Rotational symmetry is four-way for square images and two-way for other images.
+func (s Symmetry) Mirror(p image.Point, w, h int) []image.Point {
+	var (
+		mx = w - 1 - p.X
+		my = h - 1 - p.Y
+	)
+	var points []image.Point
+	switch s {
+	case symmetryVertical:
+		points = []image.Point{{mx, p.Y}}
+	case symmetryHorizontal:
+		points = []image.Point{{p.X, my}}
+	case symmetryBoth:
+		points = []image.Point{{mx, p.Y}, {p.X, my}, {mx, my}}
+	case symmetryRotational:
+		if w == h {
+			points = []image.Point{{w - 1 - p.Y, p.X}, {mx, my}, {p.Y, h - 1 - p.X}}
+		} else {
+			points = []image.Point{{mx, my}}
+		}
+	}
+	// Skip positions that are the same as the given one, like on the axis itself
+	var unique []image.Point
+	for _, mp := range points {
+		if mp != p {
+			unique = append(unique, mp)
+		}
+	}
+	return unique
+}
+
+// Paint will set the given rune at the cursor, and also at the mirrored pixel positions if symmetry is enabled
+func (e *Editor) Paint(r rune) {
+	e.SetRune(r)
+	if e.symmetry == symmetryOff || !e.AtPixel() {
+		return
+	}
+	for _, mp := range e.symmetry.Mirror(e.CursorPixel(), e.width, e.height) {
+		e.Set(mp.X*2, mp.Y, r)
+	}
+	e.redraw = true
+}
+
+// drawSymmetryGuides will draw the symmetry axes as a faint guide on top of the pixel grid,
+// which is given as rows of pixels in the textual representation
+func (e *Editor) drawSymmetryGuides(c *vt100.Canvas, rows [][]rune, cx, cy int) {
+	var (
+		z          = e.zoom()
+		w          = int(c.W())
+		h          = int(c.H()) - 1 // the last line is for the status bar
+		vertical   = e.symmetry == symmetryVertical || e.symmetry == symmetryBoth || e.symmetry == symmetryRotational
+		horizontal = e.symmetry == symmetryHorizontal || e.symmetry == symmetryBoth || e.symmetry == symmetryRotational
+		// the vertical axis is drawn in the last column of the cells for the last pixel in the left half
+		axisX = e.gridWidth()/2 - 1
+		// the horizontal axis is drawn as an underline below the last row of cells in the upper half
+		axisY = ((e.height+1)/2-e.pixelScroll)*z.h - 1
+	)
+	// screenRune returns the rune that is drawn at the given screen column, for the given pixel row
+	screenRune := func(sx, y int) rune {
+		return z.cellRune(pixelRune(rows, sx/z.w, y), sx%z.w)
+	}
+	for sy := 0; sy < (e.height-e.pixelScroll)*z.h && cy+sy < h; sy++ {
+		y := e.pixelScroll + sy/z.h
+		if vertical && cx+axisX < w {
+			r := screenRune(axisX, y)
+			if r == ' ' {
+				r = '│'
+			}
+			c.WriteRune(uint(cx+axisX), uint(cy+sy), e.guideFg, e.bg, r)
+		}
+		if horizontal && sy == axisY {
+			fg := e.fg.Combine(vt100.Underscore)
+			for sx := 0; sx < e.gridWidth() && cx+sx < w; sx++ {
+				r := screenRune(sx, y)
+				if vertical && sx == axisX && r == ' ' {
+					r = '│'
+				}
+				c.WriteRune(uint(cx+sx), uint(cy+sy), fg, e.bg, r)
+			}
+		}
+	}
+}
+
+// symmetryCommand sets the symmetry mode, like "symmetry both" or "symmetry off"
+func symmetryCommand(e *Editor, args []string) (string, error) {
+	usage := errors.New("usage: symmetry off|vertical|horizontal|both|rotational")
+	if len(args) != 1 {
+		return "", usage
+	}
+	arg := strings.ToLower(args[0])
+	for s, name := range symmetryNames {
+		if name == arg || (len(arg) == 1 && name[:1] == arg) {
+			e.symmetry = s
+			return "Symmetry: " + name, nil
+		}
+	}
+	return "", usage
+}
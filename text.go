@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+)
+
+// textUsage is the usage message for the text command, without the command name
+const textUsage = "[3x5|4x6|5x7|8x8] [at nw|n|ne|w|c|e|sw|s|se] [kern N] [layer] TEXT"
+
+// textOptions are the options for stamping text, which are given before the text itself
+type textOptions struct {
+	font     *Font       // the font, or nil for the largest one that fits
+	anchor   image.Point // where the text is placed, as for canvas
+	anchored bool        // place the text at the anchor, instead of at the cursor?
+	kern     int         // added to the spacing between the glyphs
+	layer    bool        // add the text as a new layer?
+	lines    []string
+}
+
+// parseTextArgs parses the options and the text, like "4x6 at c kern -1 CI". Line breaks are given as \n.
+// Options with a value, like "background 0", are also parsed if their names are in the given map.
+func parseTextArgs(args []string, values map[string]byte) (textOptions, error) {
+	var o textOptions
+	for len(args) > 0 {
+		arg := strings.ToLower(args[0])
+		if f, ok := fonts[arg]; ok {
+			o.font = f
+			args = args[1:]
+			continue
+		}
+		if arg == "layer" {
+			o.layer = true
+			args = args[1:]
+			continue
+		}
+		_, isValue := values[arg]
+		if (arg != "at" && arg != "kern" && !isValue) || len(args) < 2 {
+			break
+		}
+		switch arg {
+		case "at":
+			a, ok := anchors[strings.ToLower(args[1])]
+			if !ok {
+				return o, errors.New("the anchor must be nw, n, ne, w, c, e, sw, s or se")
+			}
+			o.anchor, o.anchored = a, true
+		case "kern":
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < -2 || n > 8 {
+				return o, errors.New("the kerning must be from -2 to 8 pixels")
+			}
+			o.kern = n
+		default:
+			v, err := ParseValue(args[1])
+			if err != nil {
+				return o, err
+			}
+			values[arg] = v
+		}
+		args = args[2:]
+	}
+	if len(args) == 0 {
+		return o, errors.New("no text given")
+	}
+	o.lines = strings.Split(strings.Join(args, " "), `\n`)
+	return o, nil
+}
+
+// fitFont returns the largest font where the lines of text fit within w x h pixels, or the smallest font if none of them fit
+func fitFont(lines []string, kern, w, h int) *Font {
+	for i := len(fontNames) - 1; i > 0; i-- {
+		f := fonts[fontNames[i]]
+		if t := f.Render(lines, kern, 0); t.Width() <= w && t.Height() <= h {
+			return f
+		}
+	}
+	return fonts[fontNames[0]]
+}
+
+// render returns the text, with 0 where it is drawn, using the largest font that fits in the area if no font was given.
+// The lines are aligned in the same direction as the anchor.
+func (o textOptions) render(area image.Rectangle) *Pixels {
+	f := o.font
+	if f == nil {
+		f = fitFont(o.lines, o.kern, area.Dx(), area.Dy())
+	}
+	return f.Render(o.lines, o.kern, o.anchor.X)
+}
+
+// position returns where the upper left corner of the rendered text goes, when placed within the area at the anchor
+func (o textOptions) position(area image.Rectangle, t *Pixels) image.Point {
+	return area.Min.Add(image.Pt((area.Dx()-t.Width())*o.anchor.X/2, (area.Dy()-t.Height())*o.anchor.Y/2))
+}
+
+// stampText returns a copy of the pixels, with the text drawn with the given value, with its upper left corner at the given point
+func stampText(p, t *Pixels, at image.Point, v byte) *Pixels {
+	p2 := p.Copy()
+	for y := 0; y < t.h; y++ {
+		for x := 0; x < t.w; x++ {
+			if t.At(x, y) != transparent {
+				p2.Set(at.X+x, at.Y+y, v)
+			}
+		}
+	}
+	return p2
+}
+
+// textCommand stamps text with the brush value at the cursor, or within the selection or image at an anchor point,
+// like "text 4x6 at c CI". With "layer", the text is added as a new layer, except while it is being previewed.
+func textCommand(e *Editor, args []string) (string, error) {
+	if len(args) == 0 {
+		return "", errors.New("usage: text " + textUsage)
+	}
+	o, err := parseTextArgs(args, nil)
+	if err != nil {
+		return "", err
+	}
+	area, ok := e.Selection()
+	if !ok {
+		area = image.Rect(0, 0, e.width, e.height)
+	}
+	var (
+		t   = o.render(area)
+		pos = o.position(area, t)
+		v   = e.brush
+	)
+	if !o.anchored {
+		if !e.AtPixel() {
+			return "", errors.New("not at a pixel")
+		}
+		pos = e.CursorPixel()
+	}
+	// Layers are not part of the preview, so the text is previewed on the current layer
+	if o.layer && e.previewLines == nil {
+		p := stampText(NewPixels(e.width, e.height, transparent), t, pos, v)
+		e.AddLayer("text")
+		e.SetPixels(p)
+		return "Added the text as a new layer", nil
+	}
+	return "Stamped the text onto the " + e.where(), e.Transform(func(p *Pixels) (*Pixels, error) {
+		return stampText(p, t, pos.Sub(e.selectionOffset()), v), nil
+	})
+}
+
+// textSubcommand writes an image with the given text, like "text ci.ico 16x16 CI".
+// The text is centered, unless another anchor is given, and drawn with the largest font that fits, unless a font is given.
+func textSubcommand(args []string) (string, error) {
+	usage := errors.New("usage: text OUTPUT WIDTHxHEIGHT [value 0-F] [background 0-F|T] " + strings.Replace(textUsage, " [layer]", "", 1))
+	if len(args) < 3 {
+		return "", usage
+	}
+	output := args[0]
+	w, h, err := ParseSize(args[1])
+	if err != nil {
+		return "", err
+	}
+	values := map[string]byte{"value": 15, "background": 0}
+	o, err := parseTextArgs(args[2:], values)
+	if err != nil {
+		return "", err
+	}
+	if o.layer {
+		return "", usage
+	}
+	if !o.anchored {
+		o.anchor = anchors["c"]
+	}
+	area := image.Rect(0, 0, w, h)
+	t := o.render(area)
+	p := stampText(NewPixels(w, h, values["background"]), t, o.position(area, t), values["value"])
+	if err := WriteEntries(output, []*Pixels{p}); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Wrote %q as a %dx%d image to %s", strings.Join(o.lines, " "), w, h, output), nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"image"
+	_ "image/jpeg" // for decoding JPEG reference images, next to PNG, GIF and ICO
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/xyproto/vt100"
+)
+
+// defaultTraceOpacity is the opacity of the reference image, in percent, when it is first loaded
+const defaultTraceOpacity = 50
+
+// Downscale returns the image scaled to w x h pixels, by averaging the colors of all the source pixels
+// that cover each destination pixel. Pixels that are mostly transparent become transparent.
+func Downscale(m image.Image, w, h int) *Pixels {
+	var (
+		b = m.Bounds()
+		p = NewPixels(w, h, transparent)
+	)
+	for y := 0; y < h; y++ {
+		y0, y1 := b.Min.Y+y*b.Dy()/h, b.Min.Y+(y+1)*b.Dy()/h
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < w; x++ {
+			x0, x1 := b.Min.X+x*b.Dx()/w, b.Min.X+(x+1)*b.Dx()/w
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+			var luma, alpha, n float64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					// The colors are premultiplied with alpha
+					r, g, b, a := m.At(sx, sy).RGBA()
+					luma += 0.2126*float64(r) + 0.7152*float64(g) + 0.0722*float64(b)
+					alpha += float64(a)
+					n++
+				}
+			}
+			if alpha/n < 0x8000 {
+				continue
+			}
+			// from 0..65535 to 0..15
+			v := int(math.Round(luma / alpha * 15))
+			if v > 15 {
+				v = 15
+			}
+			p.Set(x, y, byte(v))
+		}
+	}
+	return p
+}
+
+// LoadTrace will load a reference image of any size, in any format that can be decoded,
+// and scale it down to the size of the image that is being edited
+func (e *Editor) LoadTrace(filename string) error {
+	m, err := readImage(filename)
+	if err != nil {
+		return err
+	}
+	e.traceImage = m
+	e.trace = Downscale(m, e.width, e.height)
+	e.tracing = true
+	return nil
+}
+
+// traceBackground returns the background color for the cells of the pixel at x,y, which shows the reference image
+// dimmed according to the opacity. Returns false if no reference image should be shown at x,y.
+func (e *Editor) traceBackground(x, y int) (vt100.AttributeColor, bool) {
+	if !e.tracing || e.trace == nil {
+		return nil, false
+	}
+	if e.trace.Width() != e.width || e.trace.Height() != e.height {
+		// The image has been resized since the reference image was loaded
+		e.trace = Downscale(e.traceImage, e.width, e.height)
+	}
+	v := e.trace.At(x, y)
+	if v == transparent {
+		return nil, false
+	}
+	return valueBackground(byte(int(v) * e.traceOpacity / 100)), true
+}
+
+// traceCommand loads a reference image to trace, shows or hides it, or sets the opacity
+func traceCommand(e *Editor, args []string) (string, error) {
+	usage := errors.New("usage: trace FILENAME|on|off|opacity PERCENT")
+	if len(args) == 0 {
+		if e.trace == nil {
+			return "", usage
+		}
+		args = []string{"off"}
+		if !e.tracing {
+			args = []string{"on"}
+		}
+	}
+	switch strings.ToLower(args[0]) {
+	case "on":
+		if e.trace == nil {
+			return "", errors.New("no reference image, use: trace FILENAME")
+		}
+		e.tracing = true
+		return "Showing the reference image", nil
+	case "off":
+		e.tracing = false
+		return "Hiding the reference image", nil
+	case "opacity":
+		if len(args) != 2 {
+			return "", usage
+		}
+		opacity, err := strconv.Atoi(strings.TrimSuffix(args[1], "%"))
+		if err != nil || opacity < 0 || opacity > 100 {
+			return "", errors.New("the opacity must be from 0 to 100")
+		}
+		e.traceOpacity = opacity
+		return "The reference image has an opacity of " + strconv.Itoa(opacity) + "%", nil
+	}
+	filename := strings.Join(args, " ")
+	if err := e.LoadTrace(filename); err != nil {
+		return "", err
+	}
+	return "Loaded " + filename + " as a reference image", nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"image"
+)
+
+// FlipHorizontal returns a copy of the pixels, mirrored from left to right
+func (p *Pixels) FlipHorizontal() *Pixels {
+	p2 := NewPixels(p.w, p.h, transparent)
+	for y := 0; y < p.h; y++ {
+		for x := 0; x < p.w; x++ {
+			p2.Set(p.w-1-x, y, p.At(x, y))
+		}
+	}
+	return p2
+}
+
+// FlipVertical returns a copy of the pixels, mirrored from top to bottom
+func (p *Pixels) FlipVertical() *Pixels {
+	p2 := NewPixels(p.w, p.h, transparent)
+	for y := 0; y < p.h; y++ {
+		for x := 0; x < p.w; x++ {
+			p2.Set(x, p.h-1-y, p.At(x, y))
+		}
+	}
+	return p2
+}
+
+// Rotate returns a copy of the pixels, rotated clockwise by 90, 180 or 270 degrees.
+// Rotating by 90 or 270 degrees swaps the width and the height.
+func (p *Pixels) Rotate(degrees int) (*Pixels, error) {
+	switch degrees {
+	case 90:
+		p2 := NewPixels(p.h, p.w, transparent)
+		for y := 0; y < p.h; y++ {
+			for x := 0; x < p.w; x++ {
+				p2.Set(p.h-1-y, x, p.At(x, y))
+			}
+		}
+		return p2, nil
+	case 180:
+		return p.FlipHorizontal().FlipVertical(), nil
+	case 270:
+		p2 := NewPixels(p.h, p.w, transparent)
+		for y := 0; y < p.h; y++ {
+			for x := 0; x < p.w; x++ {
+				p2.Set(y, p.w-1-x, p.At(x, y))
+			}
+		}
+		return p2, nil
+	}
+	return nil, errors.New("can only rotate by 90, 180 or 270 degrees")
+}
+
+// Shift returns a copy of the pixels, moved dx pixels to the right and dy pixels down.
+// Pixels that are moved past one edge wrap around to the other edge.
+func (p *Pixels) Shift(dx, dy int) *Pixels {
+	p2 := NewPixels(p.w, p.h, transparent)
+	if p.w == 0 || p.h == 0 {
+		return p2
+	}
+	for y := 0; y < p.h; y++ {
+		for x := 0; x < p.w; x++ {
+			p2.Set(mod(x+dx, p.w), mod(y+dy, p.h), p.At(x, y))
+		}
+	}
+	return p2
+}
+
+// mod returns a modulo n, but always in the range 0..n-1, also for negative numbers
+func mod(a, n int) int {
+	return ((a % n) + n) % n
+}
+
+// Transform will apply the given function to the current selection, or to the whole image if nothing is selected.
+// When transforming a selection, the function must return pixels of the same size as it was given.
+func (e *Editor) Transform(f func(*Pixels) (*Pixels, error)) error {
+	p := e.Pixels()
+	sel, ok := e.Selection()
+	if !ok {
+		p2, err := f(p)
+		if err != nil {
+			return err
+		}
+		e.SetPixels(p2)
+		return nil
+	}
+	sub, err := f(p.Sub(sel))
+	if err != nil {
+		return err
+	}
+	if sub.Bounds() != image.Rect(0, 0, sel.Dx(), sel.Dy()) {
+		return errors.New("the selection must be square for this transformation")
+	}
+	p.Paste(sub, sel.Min)
+	e.SetPixels(p)
+	return nil
+}
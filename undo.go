@@ -2,67 +2,607 @@ package main
 
 import (
 	"errors"
+	"image"
+	"sort"
+	"strconv"
 	"sync"
 )
 
-// Undo is a struct that can store several states of the editor and position
+// groupedUndoSteps are the kinds of edits where consecutive edits are grouped into a single undo step,
+// like all the pixels in a painting stroke
+var groupedUndoSteps = map[string]bool{
+	"paint": true,
+	"erase": true,
+}
+
+// lineDelta is the contents of one line before and after an edit
+type lineDelta struct {
+	before    []rune
+	after     []rune
+	hadBefore bool // did the line exist before the edit?
+	hasAfter  bool // does the line exist after the edit?
+}
+
+// undoStep is one logical undo step, and also the state of the editor after that step.
+// Only the lines that were changed are stored. The steps form a tree, so that branching off
+// from an earlier state keeps the newer states reachable.
+type undoStep struct {
+	id            int                // a number that increases for each new step
+	what          string             // a description of the edit, like "paint" or "rotate 90"
+	count         int                // the number of edits that are grouped in this step
+	lines         map[int]*lineDelta // the changed lines, by line index
+	posBefore     Position
+	posAfter      Position
+	sizeBefore    [2]int      // width and height before the edit
+	sizeAfter     [2]int      // width and height after the edit
+	layersBefore  *Layers     // the layers before the edit, if the edit changed the layers
+	layersAfter   *Layers     // the layers after the edit, if the edit changed the layers
+	framesBefore  *Frames     // the frames before the edit, if the edit changed the frames
+	framesAfter   *Frames     // the frames after the edit, if the edit changed the frames
+	entries       bool        // did the edit change the other .ico entries?
+	entriesBefore []*Pixels   // the other .ico entries before the edit, if the edit changed them
+	entriesAfter  []*Pixels   // the other .ico entries after the edit, if the edit changed them
+	memoryCounted int         // the approximate number of bytes used by this step
+	name          string      // the name of the checkpoint, if this state is a named checkpoint
+	parent        *undoStep   // the state before this step, or nil for the oldest state
+	children      []*undoStep // the states after this one, the one to redo last
+}
+
+// Undo is a struct that can store the edits made in the editor, as per-line deltas, for undo and redo.
+// The oldest undo steps are forgotten when the memory budget is exceeded.
 type Undo struct {
-	index                int
-	size                 int
-	editorCopies         []Editor
-	editorLineCopies     []map[int][]rune
-	editorPositionCopies []Position
-	hasSomething         []bool
-	mut                  *sync.RWMutex
+	budget   int         // the maximum number of bytes that the undo steps may use
+	used     int         // the approximate number of bytes used by the undo steps
+	root     *undoStep   // the oldest state that can be reached
+	current  *undoStep   // the current state
+	nextID   int         // the id of the next undo step
+	pos      Position    // the cursor position before the edit that is in progress
+	size     [2]int      // the image size before the edit that is in progress
+	command  bool        // can the edit that is in progress change the layers and frames?
+	layers   *Layers     // the layers before the edit that is in progress, for commands
+	frames   *Frames     // the frames before the edit that is in progress, for commands
+	entries  []*Pixels   // the other .ico entries before the edit that is in progress, for commands
+	what     string      // a description of the edit that is in progress
+	grouping bool        // can the next edit be grouped with the last undo step?
+	pixel    image.Point // the cursor pixel before the edit that is in progress
+	last     image.Point // the cursor pixel after the last edit
+	compare  *undoStep   // the state to compare with, or nil to use the last checkpoint
+	mut      *sync.RWMutex
+}
+
+// NewUndo takes the memory budget for the undo history, in bytes
+func NewUndo(budget int) *Undo {
+	root := &undoStep{what: "opened", count: 1, lines: make(map[int]*lineDelta)}
+	return &Undo{budget: budget, root: root, current: root, nextID: 1, mut: &sync.RWMutex{}}
+}
+
+// saveLine will remember how the given line was before the edit that is recorded for undo, if it has not been
+// remembered already. This must be called before a line is changed. Lines that do not exist are remembered as nil.
+func (e *Editor) saveLine(y int) {
+	if e.undoLines == nil {
+		// No edit is being recorded
+		return
+	}
+	if _, ok := e.undoLines[y]; ok {
+		return
+	}
+	var before []rune
+	if runes, ok := e.lines[y]; ok {
+		before = append(make([]rune, 0, len(runes)), runes...)
+	}
+	e.undoLines[y] = before
+}
+
+// saveAllLines will remember all the lines before an edit that moves lines around or replaces all of them.
+// Lines that are added after this, without being saved, did not exist before the edit.
+func (e *Editor) saveAllLines() {
+	if e.undoLines == nil {
+		return
+	}
+	for y := range e.lines {
+		e.saveLine(y)
+	}
+	e.undoAll = true
+}
+
+// Snapshot will start recording an edit of the given kind, that only changes the lines, like painting a pixel.
+// Only the lines that are changed are copied, as they are changed. The edit is stored as an undo step when
+// Commit is called.
+func (u *Undo) Snapshot(e *Editor, what string) {
+	u.snapshot(e, what, false)
 }
 
-// NewUndo takes arguments that are only for initializing the undo buffers.
-// The *Position and *vt100.Canvas is used only as a default values for the elements in the undo buffers.
-func NewUndo(size int) *Undo {
-	return &Undo{0, size, make([]Editor, size), make([]map[int][]rune, size), make([]Position, size), make([]bool, size), &sync.RWMutex{}}
+// SnapshotCommand will start recording an edit that can also change the layers, frames and .ico entries, like a command
+func (u *Undo) SnapshotCommand(e *Editor, what string) {
+	u.snapshot(e, what, true)
 }
 
-// Snapshot will store a snapshot, and move to the next position in the circular buffer
-func (u *Undo) Snapshot(e *Editor) {
+// snapshot will start recording an edit, and remember the layers, frames and .ico entries if the edit can change them
+func (u *Undo) snapshot(e *Editor, what string, command bool) {
 	u.mut.Lock()
 	defer u.mut.Unlock()
 
-	u.hasSomething[u.index] = true
-	u.editorCopies[u.index] = *e
-	u.editorLineCopies[u.index] = e.CopyLines()
-	u.editorPositionCopies[u.index] = e.pos
+	if e.undoLines != nil {
+		// An edit is already in progress
+		return
+	}
+	e.undoLines = make(map[int][]rune)
+	e.undoAll = false
+	u.pos = e.pos
+	u.pixel = e.CursorPixel()
+	u.size = [2]int{e.width, e.height}
+	u.command = command
+	u.layers, u.frames, u.entries = nil, nil, nil
+	if command {
+		u.layers = e.layers.share()
+		u.frames = e.frames.share()
+		// The entries are replaced, and not changed, so only the list is copied
+		u.entries = append([]*Pixels(nil), e.entries...)
+	}
+	u.what = what
+}
+
+// diff returns the lines that differ between the lines that were saved while recording the edit,
+// and the current editor lines
+func (u *Undo) diff(e *Editor) map[int]*lineDelta {
+	deltas := make(map[int]*lineDelta)
+	for y, before := range e.undoLines {
+		after, hasAfter := e.lines[y]
+		hadBefore := before != nil
+		if hadBefore != hasAfter || string(before) != string(after) {
+			deltas[y] = &lineDelta{before: before, hadBefore: hadBefore, hasAfter: hasAfter}
+			if hasAfter {
+				deltas[y].after = append([]rune{}, after...)
+			}
+		}
+	}
+	if e.undoAll {
+		// Lines that were added without being saved did not exist before the edit
+		for y, after := range e.lines {
+			if _, ok := e.undoLines[y]; !ok {
+				deltas[y] = &lineDelta{after: append([]rune{}, after...), hasAfter: true}
+			}
+		}
+	}
+	return deltas
+}
 
-	// Go forward 1 step in the circular buffer
-	u.index++
-	// Circular buffer wrap
-	if u.index >= u.size {
-		u.index = 0
+// memory returns the approximate number of bytes used by the undo step
+func (step *undoStep) memory() int {
+	const overhead = 64 // for the struct, the map entry and the slice headers
+	n := overhead
+	for _, d := range step.lines {
+		n += overhead + 4*(len(d.before)+len(d.after))
+	}
+	if step.layersBefore != nil {
+		n += step.layersBefore.memory() + step.layersAfter.memory()
+	}
+	if step.framesBefore != nil {
+		n += step.framesBefore.memory() + step.framesAfter.memory()
 	}
+	if step.entries {
+		for _, entry := range append(step.entriesBefore, step.entriesAfter...) {
+			n += overhead + len(entry.values)
+		}
+	}
+	return n
 }
 
-// Restore will restore a previous snapshot, and move to the previous position in the circular buffer
-func (u *Undo) Restore(e *Editor) error {
+// Commit will store the edit that was started with Snapshot as an undo step, if anything changed.
+// Consecutive edits of a kind that is listed in groupedUndoSteps are grouped into one undo step,
+// as long as each edit starts next to where the previous one ended, so that separate strokes are separate steps.
+// If the current state is an earlier one, the new step starts a new branch.
+func (u *Undo) Commit(e *Editor) {
 	u.mut.Lock()
 	defer u.mut.Unlock()
 
-	// Go back 1 step in the circular buffer
-	u.index--
-	// Circular buffer wrap
-	if u.index < 0 {
-		u.index = u.size - 1
+	if e.undoLines == nil {
+		return
+	}
+	deltas := u.diff(e)
+	e.undoLines, e.undoAll = nil, false
+	size := [2]int{e.width, e.height}
+	layersChanged := u.command && !u.layers.Equal(e.layers)
+	framesChanged := u.command && !u.frames.Equal(e.frames)
+	entriesChanged := u.command && !sameEntries(u.entries, e.entries)
+	if len(deltas) == 0 && u.size == size && !layersChanged && !framesChanged && !entriesChanged {
+		// Nothing changed, only the cursor may have moved
+		return
+	}
+	last := u.last
+	u.last = e.CursorPixel()
+	if d := u.pixel.Sub(last); d.X < -1 || d.X > 1 || d.Y < -1 || d.Y > 1 {
+		// The cursor jumped away from the previous edit
+		u.grouping = false
+	}
+	if step := u.current; u.grouping && !layersChanged && !framesChanged && !entriesChanged && step != u.root && len(step.children) == 0 && step.what == u.what && groupedUndoSteps[u.what] {
+		// Group this edit with the previous one, keeping the oldest "before" for each line
+		for y, d := range deltas {
+			if old, ok := step.lines[y]; ok {
+				old.after, old.hasAfter = d.after, d.hasAfter
+			} else {
+				step.lines[y] = d
+			}
+		}
+		step.posAfter = e.pos
+		step.sizeAfter = size
+		step.count++
+		u.recount(step)
+		return
+	}
+	step := &undoStep{id: u.nextID, what: u.what, count: 1, lines: deltas, posBefore: u.pos, posAfter: e.pos, sizeBefore: u.size, sizeAfter: size, parent: u.current}
+	if layersChanged {
+		step.layersBefore = u.layers
+		step.layersAfter = e.layers.share()
+	}
+	if framesChanged {
+		step.framesBefore = u.frames
+		step.framesAfter = e.frames.share()
+	}
+	if entriesChanged {
+		step.entries = true
+		step.entriesBefore = u.entries
+		step.entriesAfter = append([]*Pixels(nil), e.entries...)
+	}
+	u.nextID++
+	u.current.children = append(u.current.children, step)
+	u.current = step
+	u.recount(step)
+	u.grouping = true
+}
+
+// sameEntries returns true if the two lists of .ico entries have the same images
+func sameEntries(a, b []*Pixels) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// recount will update the memory usage of the given step, and forget the oldest steps if the budget is exceeded
+func (u *Undo) recount(step *undoStep) {
+	m := step.memory()
+	u.used += m - step.memoryCounted
+	step.memoryCounted = m
+	u.forget()
+}
+
+// forgetBranch will subtract the memory used by the given step and all the steps after it
+func (u *Undo) forgetBranch(step *undoStep) {
+	u.used -= step.memoryCounted
+	for _, child := range step.children {
+		u.forgetBranch(child)
+	}
+}
+
+// forget will remove the oldest undo steps until the memory budget is no longer exceeded.
+// The step after the oldest state, on the way to the current state, becomes the new oldest state,
+// and branches that split off from the oldest state are forgotten.
+// The current state is always kept.
+func (u *Undo) forget() {
+	for u.used > u.budget && u.current != u.root {
+		next := u.current
+		for next.parent != u.root {
+			next = next.parent
+		}
+		for _, child := range u.root.children {
+			if child != next {
+				u.forgetBranch(child)
+			}
+		}
+		// The new oldest state can not be undone, so the changed lines are no longer needed
+		u.used -= next.memoryCounted
+		next.lines = make(map[int]*lineDelta)
+		next.memoryCounted = 0
+		next.parent = nil
+		u.root = next
+	}
+}
+
+// EndGroup makes sure that the next edit is not grouped with the previous one
+func (u *Undo) EndGroup() {
+	u.mut.Lock()
+	u.grouping = false
+	u.mut.Unlock()
+}
+
+// applyLines will set the given lines to how they were either before or after the given step
+func (step *undoStep) applyLines(lines map[int][]rune, before bool) {
+	for y, d := range step.lines {
+		runes, ok := d.after, d.hasAfter
+		if before {
+			runes, ok = d.before, d.hadBefore
+		}
+		if ok {
+			lines[y] = append([]rune{}, runes...)
+		} else {
+			delete(lines, y)
+		}
+	}
+}
+
+// apply will set the lines, position, image size, layers, frames and .ico entries from either before or after the given step
+func (step *undoStep) apply(e *Editor, before bool) {
+	step.applyLines(e.lines, before)
+	size := step.sizeAfter
+	e.pos = step.posAfter
+	if before {
+		size = step.sizeBefore
+		e.pos = step.posBefore
 	}
+	e.width, e.height = size[0], size[1]
+	if step.layersBefore != nil {
+		if before {
+			e.layers = step.layersBefore.Copy()
+		} else {
+			e.layers = step.layersAfter.Copy()
+		}
+	}
+	if step.framesBefore != nil {
+		if before {
+			e.frames = step.framesBefore.Copy()
+		} else {
+			e.frames = step.framesAfter.Copy()
+		}
+	}
+	if step.entries {
+		if before {
+			e.entries = append([]*Pixels(nil), step.entriesBefore...)
+		} else {
+			e.entries = append([]*Pixels(nil), step.entriesAfter...)
+		}
+	}
+	e.changed = true
+}
 
-	// Restore the state from this index, if there is something there
-	if u.hasSomething[u.index] {
-		*e = u.editorCopies[u.index]
-		e.lines = u.editorLineCopies[u.index]
-		e.pos = u.editorPositionCopies[u.index]
-		return nil
+// Restore will go back to the state before the current one, and make it possible to redo the current one.
+// Returns a description of what was undone.
+func (u *Undo) Restore(e *Editor) (string, error) {
+	u.mut.Lock()
+	defer u.mut.Unlock()
+
+	if u.current == u.root {
+		return "", errors.New("no more to undo")
 	}
-	return errors.New("no undo state at this index")
+	step := u.current
+	step.apply(e, true)
+	u.current = step.parent
+	u.preferChild(step)
+	u.grouping = false
+	return step.String(), nil
 }
 
-// Index will return the current undo index, in the undo buffers
+// preferChild will make the given step the one that is redone from its parent state
+func (u *Undo) preferChild(step *undoStep) {
+	siblings := step.parent.children
+	for i, sibling := range siblings {
+		if sibling == step {
+			siblings = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+	step.parent.children = append(siblings, step)
+}
+
+// Redo will redo the step that was most recently undone from the current state.
+// Returns a description of what was redone.
+func (u *Undo) Redo(e *Editor) (string, error) {
+	u.mut.Lock()
+	defer u.mut.Unlock()
+
+	if len(u.current.children) == 0 {
+		return "", errors.New("no more to redo")
+	}
+	step := u.current.children[len(u.current.children)-1]
+	step.apply(e, false)
+	u.current = step
+	u.grouping = false
+	return step.String(), nil
+}
+
+// path returns the steps to undo and then the steps to redo, to get from one state to another
+func path(from, to *undoStep) (up, down []*undoStep) {
+	ancestors := make(map[*undoStep]bool)
+	for s := from; s != nil; s = s.parent {
+		ancestors[s] = true
+	}
+	// Find the closest common state, while collecting the steps to redo
+	common := to
+	for !ancestors[common] {
+		down = append([]*undoStep{common}, down...)
+		common = common.parent
+	}
+	for s := from; s != common; s = s.parent {
+		up = append(up, s)
+	}
+	return up, down
+}
+
+// JumpTo will undo and redo steps until the editor is at the given state, even if it is on another branch.
+// Returns a description of the state.
+func (u *Undo) JumpTo(e *Editor, target *undoStep) string {
+	u.mut.Lock()
+	defer u.mut.Unlock()
+
+	up, down := path(u.current, target)
+	for _, step := range up {
+		step.apply(e, true)
+		u.preferChild(step)
+	}
+	for _, step := range down {
+		step.apply(e, false)
+		u.preferChild(step)
+	}
+	u.current = target
+	u.grouping = false
+	return target.String()
+}
+
+// LinesAt returns a copy of the editor lines and the image size, as they are at the given state
+func (u *Undo) LinesAt(e *Editor, target *undoStep) (map[int][]rune, int, int) {
+	u.mut.RLock()
+	defer u.mut.RUnlock()
+
+	lines := e.CopyLines()
+	w, h := e.width, e.height
+	up, down := path(u.current, target)
+	for _, step := range up {
+		step.applyLines(lines, true)
+		w, h = step.sizeBefore[0], step.sizeBefore[1]
+	}
+	for _, step := range down {
+		step.applyLines(lines, false)
+		w, h = step.sizeAfter[0], step.sizeAfter[1]
+	}
+	return lines, w, h
+}
+
+// LayersAt returns a copy of the layers, as they are at the given state
+func (u *Undo) LayersAt(e *Editor, target *undoStep) *Layers {
+	u.mut.RLock()
+	defer u.mut.RUnlock()
+
+	layers := e.layers
+	up, down := path(u.current, target)
+	for _, step := range up {
+		if step.layersBefore != nil {
+			layers = step.layersBefore
+		}
+	}
+	for _, step := range down {
+		if step.layersAfter != nil {
+			layers = step.layersAfter
+		}
+	}
+	return layers.Copy()
+}
+
+// FramesAt returns a copy of the frames, as they are at the given state
+func (u *Undo) FramesAt(e *Editor, target *undoStep) *Frames {
+	u.mut.RLock()
+	defer u.mut.RUnlock()
+
+	frames := e.frames
+	up, down := path(u.current, target)
+	for _, step := range up {
+		if step.framesBefore != nil {
+			frames = step.framesBefore
+		}
+	}
+	for _, step := range down {
+		if step.framesAfter != nil {
+			frames = step.framesAfter
+		}
+	}
+	return frames.Copy()
+}
+
+// States returns all the states that can be reached, the oldest one first
+func (u *Undo) States() []*undoStep {
+	u.mut.RLock()
+	defer u.mut.RUnlock()
+
+	var states []*undoStep
+	var collect func(*undoStep)
+	collect = func(step *undoStep) {
+		states = append(states, step)
+		for _, child := range step.children {
+			collect(child)
+		}
+	}
+	collect(u.root)
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].id < states[j].id
+	})
+	return states
+}
+
+// Current returns the current state
+func (u *Undo) Current() *undoStep {
+	u.mut.RLock()
+	defer u.mut.RUnlock()
+	return u.current
+}
+
+// Checkpoint will give the current state a name, so that it is easy to find later
+func (u *Undo) Checkpoint(name string) {
+	u.mut.Lock()
+	u.current.name = name
+	u.grouping = false
+	u.mut.Unlock()
+}
+
+// SetName will give the given state a name, or remove the name if name is empty
+func (u *Undo) SetName(step *undoStep, name string) {
+	u.mut.Lock()
+	step.name = name
+	u.mut.Unlock()
+}
+
+// SetCompare selects the state that the image is compared with
+func (u *Undo) SetCompare(step *undoStep) {
+	u.mut.Lock()
+	u.compare = step
+	u.mut.Unlock()
+}
+
+// CompareTarget returns the state that the image should be compared with, which is either the selected state,
+// if it can still be reached, or the most recently created checkpoint. Returns nil if there is no such state.
+func (u *Undo) CompareTarget() *undoStep {
+	u.mut.RLock()
+	compare := u.compare
+	for s := compare; s != nil; s = s.parent {
+		if s == u.root {
+			u.mut.RUnlock()
+			return compare
+		}
+	}
+	u.mut.RUnlock()
+	return u.LastCheckpoint()
+}
+
+// LastCheckpoint returns the most recently created state that has a name, or nil
+func (u *Undo) LastCheckpoint() *undoStep {
+	var found *undoStep
+	for _, step := range u.States() {
+		if step.name != "" {
+			found = step
+		}
+	}
+	return found
+}
+
+// String returns a description of the undo step, like "paint (12 edits)"
+func (step *undoStep) String() string {
+	s := step.what
+	if step.count > 1 {
+		s += " (" + strconv.Itoa(step.count) + " edits)"
+	}
+	if step.name != "" {
+		s = step.name + ": " + s
+	}
+	return s
+}
+
+// Index will return the number of undo steps that are available from the current state
 func (u *Undo) Index() int {
-	return u.index
+	u.mut.RLock()
+	defer u.mut.RUnlock()
+	n := 0
+	for s := u.current; s != u.root; s = s.parent {
+		n++
+	}
+	return n
+}
+
+// Memory returns the approximate number of bytes used by the undo history
+func (u *Undo) Memory() int {
+	u.mut.RLock()
+	defer u.mut.RUnlock()
+	return u.used
 }
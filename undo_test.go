@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/xyproto/vt100"
+)
+
+// newTestEditor returns an editor with a w x h image, filled with the given value, as if it was loaded from a file
+func newTestEditor(w, h int, fill byte) *Editor {
+	e := NewEditor(vt100.LightGreen, vt100.BackgroundDefault, true, 10, vt100.LightMagenta, modeGray4)
+	e.drawMode = true
+	e.SetPixels(NewPixels(w, h, fill))
+	e.changed = false
+	return e
+}
+
+// editorState returns the lines, the image size and the layers and frames of the editor as a string, for comparisons
+func editorState(e *Editor) string {
+	var ys []int
+	for y := range e.lines {
+		ys = append(ys, y)
+	}
+	sort.Ints(ys)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%dx%d\n", e.width, e.height)
+	for _, y := range ys {
+		fmt.Fprintf(&sb, "%d: %s\n", y, string(e.lines[y]))
+	}
+	for _, l := range e.layers.stack {
+		fmt.Fprintf(&sb, "layer %s\n", l)
+		if l.pixels != nil {
+			sb.WriteString(pixelsText(l.pixels) + "\n")
+		}
+	}
+	fmt.Fprintf(&sb, "layer %d of %d, frame %d of %d\n", e.layers.active, e.layers.Len(), e.frames.active, e.frames.Len())
+	return sb.String()
+}
+
+// paintAt paints one pixel with the given value, as when p is pressed
+func paintAt(u *Undo, e *Editor, x, y int, v byte) {
+	e.GoToPixel(x, y)
+	u.Snapshot(e, "paint")
+	e.brush = v
+	e.PaintBrush()
+	u.Commit(e)
+}
+
+// runCommand runs a command, as when it is given after pressing ctrl-o
+func runCommand(t *testing.T, u *Undo, e *Editor, cmd string) {
+	u.SnapshotCommand(e, cmd)
+	if _, err := e.RunCommand(cmd); err != nil {
+		t.Fatalf("%s: %v", cmd, err)
+	}
+	u.Commit(e)
+}
+
+// recordEdits makes edits of every kind, and returns the full state after each undo step,
+// the way the undo history stored the state before it only stored per-line deltas
+func recordEdits(t *testing.T, u *Undo, e *Editor) []string {
+	states := []string{editorState(e)}
+	step := func() {
+		u.EndGroup()
+		states = append(states, editorState(e))
+	}
+	paintAt(u, e, 1, 1, 0)
+	paintAt(u, e, 2, 1, 0)
+	paintAt(u, e, 3, 2, 0)
+	step()
+	for _, cmd := range []string{"flip h", "rotate 90", "canvas 20x12 nw fill 3", "layer add top"} {
+		runCommand(t, u, e, cmd)
+		step()
+	}
+	paintAt(u, e, 4, 4, 9)
+	step()
+	for _, cmd := range []string{"shift right 2", "layer opacity 40", "frame add", "frame prev", "scale 40x24"} {
+		runCommand(t, u, e, cmd)
+		step()
+	}
+	paintAt(u, e, 0, 0, 15)
+	step()
+	return states
+}
+
+func TestUndoRedo(t *testing.T) {
+	e := newTestEditor(16, 16, 7)
+	u := NewUndo(1 << 24)
+	states := recordEdits(t, u, e)
+	if u.Index() != len(states)-1 {
+		t.Fatalf("expected %d undo steps, got %d", len(states)-1, u.Index())
+	}
+	for i := len(states) - 2; i >= 0; i-- {
+		if _, err := u.Restore(e); err != nil {
+			t.Fatal(err)
+		}
+		if got := editorState(e); got != states[i] {
+			t.Fatalf("after undoing to state %d, expected:\n%s\ngot:\n%s", i, states[i], got)
+		}
+	}
+	if _, err := u.Restore(e); err == nil {
+		t.Error("expected nothing more to undo")
+	}
+	for i := 1; i < len(states); i++ {
+		if _, err := u.Redo(e); err != nil {
+			t.Fatal(err)
+		}
+		if got := editorState(e); got != states[i] {
+			t.Fatalf("after redoing to state %d, expected:\n%s\ngot:\n%s", i, states[i], got)
+		}
+	}
+	if _, err := u.Redo(e); err == nil {
+		t.Error("expected nothing more to redo")
+	}
+}
+
+func TestUndoJumpTo(t *testing.T) {
+	e := newTestEditor(16, 16, 7)
+	u := NewUndo(1 << 24)
+	states := recordEdits(t, u, e)
+	steps := u.States()
+
+	// Branch off from an earlier state, so that the other states are on another branch
+	u.JumpTo(e, steps[3])
+	runCommand(t, u, e, "invert")
+	branch := u.Current()
+	branchState := editorState(e)
+
+	for _, i := range []int{len(states) - 1, 0, 5, 2, len(states) - 3} {
+		u.JumpTo(e, steps[i])
+		if got := editorState(e); got != states[i] {
+			t.Fatalf("after jumping to state %d, expected:\n%s\ngot:\n%s", i, states[i], got)
+		}
+		lines, w, h := u.LinesAt(e, branch)
+		e2 := newTestEditor(1, 1, 0)
+		e2.lines, e2.width, e2.height = lines, w, h
+		e2.layers = u.LayersAt(e, branch)
+		e2.frames = u.FramesAt(e, branch)
+		if got := editorState(e2); got != branchState {
+			t.Fatalf("the state of the other branch, seen from state %d, expected:\n%s\ngot:\n%s", i, branchState, got)
+		}
+	}
+	u.JumpTo(e, branch)
+	if got := editorState(e); got != branchState {
+		t.Fatalf("after jumping to the other branch, expected:\n%s\ngot:\n%s", branchState, got)
+	}
+}
+
+func TestUndoGroupsStrokes(t *testing.T) {
+	e := newTestEditor(16, 16, 7)
+	u := NewUndo(1 << 24)
+	// Two strokes, where the second one starts far away from where the first one ended
+	paintAt(u, e, 1, 1, 0)
+	paintAt(u, e, 2, 1, 0)
+	paintAt(u, e, 3, 2, 0)
+	paintAt(u, e, 10, 10, 0)
+	paintAt(u, e, 11, 10, 0)
+	if u.Index() != 2 {
+		t.Fatalf("expected 2 undo steps, got %d", u.Index())
+	}
+	if what := u.Current().String(); what != "paint (2 edits)" {
+		t.Errorf("expected the last step to be paint (2 edits), got %s", what)
+	}
+}
+
+func TestUndoEntries(t *testing.T) {
+	e := newTestEditor(16, 16, 7)
+	e.filename = "favicon.ico"
+	u := NewUndo(1 << 24)
+	runCommand(t, u, e, "scale 32x32 entry")
+	runCommand(t, u, e, "scale 48x48 entry")
+	runCommand(t, u, e, "entry remove 32x32")
+	for _, expected := range []string{"32x32, 48x48", "32x32", ""} {
+		if _, err := u.Restore(e); err != nil {
+			t.Fatal(err)
+		}
+		if got := e.entrySizes(); got != expected {
+			t.Errorf("after undoing, expected the entries %q, got %q", expected, got)
+		}
+	}
+	for _, expected := range []string{"32x32", "32x32, 48x48", "48x48"} {
+		if _, err := u.Redo(e); err != nil {
+			t.Fatal(err)
+		}
+		if got := e.entrySizes(); got != expected {
+			t.Errorf("after redoing, expected the entries %q, got %q", expected, got)
+		}
+	}
+}
+
+func TestUndoGenerate(t *testing.T) {
+	e := newTestEditor(16, 16, 7)
+	u := NewUndo(1 << 24)
+	before := editorState(e)
+	runCommand(t, u, e, "generate identicon billing-api sizes 16,32,48")
+	after := editorState(e)
+	if got := e.entrySizes(); got != "32x32, 48x48" {
+		t.Fatalf("expected generated 32x32 and 48x48 entries, got %q", got)
+	}
+	if _, err := u.Restore(e); err != nil {
+		t.Fatal(err)
+	}
+	if got := editorState(e); got != before || len(e.entries) != 0 {
+		t.Errorf("expected the image and entries from before generating, got %q and %q", got, e.entrySizes())
+	}
+	if _, err := u.Redo(e); err != nil {
+		t.Fatal(err)
+	}
+	if got := editorState(e); got != after || e.entrySizes() != "32x32, 48x48" {
+		t.Errorf("expected the generated image and entries, got %q and %q", got, e.entrySizes())
+	}
+}
+
+func TestUndoOnlyStoresChangedLines(t *testing.T) {
+	e := newTestEditor(64, 64, 7)
+	u := NewUndo(1 << 24)
+	paintAt(u, e, 5, 9, 0)
+	step := u.Current()
+	if len(step.lines) != 1 || step.lines[9] == nil {
+		t.Fatalf("expected only line 9 to be stored, got %d lines", len(step.lines))
+	}
+	if step.layersBefore != nil || step.framesBefore != nil {
+		t.Error("expected painting to not store the layers or frames")
+	}
+}
+
+// benchmarkEditor returns an editor with a size x size image, with some layers and frames
+func benchmarkEditor(b *testing.B, size int) (*Editor, *Undo) {
+	e := newTestEditor(size, size, 7)
+	u := NewUndo(16 << 20)
+	for _, cmd := range []string{"layer add", "layer add", "frame duplicate", "frame duplicate", "frame duplicate"} {
+		if _, err := e.RunCommand(cmd); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return e, u
+}
+
+func BenchmarkSnapshot(b *testing.B) {
+	for _, size := range []int{16, 256} {
+		b.Run(fmt.Sprintf("%dx%d", size, size), func(b *testing.B) {
+			e, u := benchmarkEditor(b, size)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				u.Snapshot(e, "paint")
+				u.Commit(e)
+			}
+		})
+	}
+}
+
+func BenchmarkCommit(b *testing.B) {
+	for _, size := range []int{16, 256} {
+		b.Run(fmt.Sprintf("%dx%d", size, size), func(b *testing.B) {
+			e, u := benchmarkEditor(b, size)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				e.GoToPixel(i%size, (i/size)%size)
+				u.Snapshot(e, "paint")
+				e.brush = byte(i % 16)
+				e.PaintBrush()
+				u.Commit(e)
+				u.EndGroup()
+			}
+		})
+	}
+}
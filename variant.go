@@ -0,0 +1,361 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// envColors are the default colors of the badges, banners and tints for common environments
+var envColors = map[string]color.NRGBA{
+	"dev":         {0x22, 0xaa, 0x22, 0xff},
+	"development": {0x22, 0xaa, 0x22, 0xff},
+	"test":        {0x22, 0x66, 0xdd, 0xff},
+	"staging":     {0xff, 0x88, 0x00, 0xff},
+	"stage":       {0xff, 0x88, 0x00, 0xff},
+	"prod":        {0xdd, 0x11, 0x11, 0xff},
+	"production":  {0xdd, 0x11, 0x11, 0xff},
+}
+
+// otherEnvColors are picked from for other environments, from a hash of the name
+var otherEnvColors = []color.NRGBA{
+	{0x88, 0x33, 0xcc, 0xff},
+	{0x11, 0x99, 0x99, 0xff},
+	{0xcc, 0x33, 0x99, 0xff},
+	{0x99, 0x77, 0x22, 0xff},
+}
+
+// envLabels are the banner labels for environments with long names. Other names are shortened to 4 letters.
+var envLabels = map[string]string{
+	"development": "DEV",
+	"staging":     "STG",
+	"production":  "PROD",
+}
+
+// variantEffect is a change that marks an icon as belonging to an environment
+type variantEffect struct {
+	kind     string // "badge", "banner", "tint" or "gray"
+	c        color.NRGBA
+	hasColor bool // was the color given, instead of being picked for the environment?
+	percent  int  // how strong the tint is
+}
+
+// variant is a set of effects for one environment, like a badge and a tint for "staging"
+type variant struct {
+	env     string
+	label   string
+	effects []variantEffect
+}
+
+// variantUsage is the usage message for the variant command, without the command name
+const variantUsage = "ENV[,ENV...] [badge [COLOR]] [banner [COLOR]] [tint [COLOR] [PERCENT]] [gray], where COLOR is 0-F or RRGGBB"
+
+// parseVariantColor parses a gray level from 0 to F, or a color given as RRGGBB or #RRGGBB
+func parseVariantColor(s string) (color.NRGBA, error) {
+	if v, err := ParseValue(s); err == nil && v != transparent {
+		intensity := v*16 + v // from 0..15 to 0..255
+		return color.NRGBA{intensity, intensity, intensity, 0xff}, nil
+	}
+	if strings.ToUpper(s) == "T" {
+		return color.NRGBA{}, errors.New("the color can not be transparent")
+	}
+	return parseColor(s)
+}
+
+// parseVariants parses the environments and the effects, like "dev,staging banner tint 30".
+// Without any effects, a badge is added. The colors that are not given are picked for each environment.
+func parseVariants(args []string) ([]variant, error) {
+	usage := errors.New("usage: variant " + variantUsage)
+	if len(args) == 0 {
+		return nil, usage
+	}
+	var effects []variantEffect
+	for i := 1; i < len(args); i++ {
+		eff := variantEffect{kind: strings.ToLower(args[i]), percent: 40}
+		switch eff.kind {
+		case "badge", "banner", "tint", "gray":
+		default:
+			return nil, usage
+		}
+		if eff.kind != "gray" && i+1 < len(args) {
+			if c, err := parseVariantColor(args[i+1]); err == nil {
+				eff.c, eff.hasColor = c, true
+				i++
+			}
+		}
+		if eff.kind == "tint" && i+1 < len(args) {
+			if n, err := strconv.Atoi(strings.TrimSuffix(args[i+1], "%")); err == nil {
+				if n < 1 || n > 100 {
+					return nil, errors.New("the tint must be from 1 to 100 percent")
+				}
+				eff.percent = n
+				i++
+			}
+		}
+		effects = append(effects, eff)
+	}
+	if len(effects) == 0 {
+		effects = append(effects, variantEffect{kind: "badge"})
+	}
+	var variants []variant
+	for _, env := range strings.Split(args[0], ",") {
+		env = strings.ToLower(env)
+		if env == "" || strings.ContainsAny(env, `/\`) {
+			return nil, errors.New("invalid environment name: " + env)
+		}
+		c, ok := envColors[env]
+		if !ok {
+			hash := sha256.Sum256([]byte(env))
+			c = otherEnvColors[int(hash[0])%len(otherEnvColors)]
+		}
+		label, ok := envLabels[env]
+		if !ok {
+			label = strings.ToUpper(env)
+			if len([]rune(label)) > 4 {
+				label = string([]rune(label)[:4])
+			}
+		}
+		v := variant{env: env, label: label}
+		for _, eff := range effects {
+			if !eff.hasColor {
+				eff.c = c
+			}
+			v.effects = append(v.effects, eff)
+		}
+		variants = append(variants, v)
+	}
+	return variants, nil
+}
+
+// Filename returns the filename of the variant of the given file, like "favicon-staging.ico" for "favicon.ico".
+// Variants of .fav files are written as .ico files.
+func (v variant) Filename(filename string) string {
+	ext := filepath.Ext(filename)
+	newExt := ext
+	if ext == ".fav" {
+		newExt = ".ico"
+	}
+	return strings.TrimSuffix(filename, ext) + "-" + v.env + newExt
+}
+
+// Marks in the overlays of badges and banners
+const (
+	overlayFill byte = 1 // the color of the effect
+	overlayInk  byte = 2 // black or white, whichever stands out the most from the color of the effect
+	overlayEdge byte = 3 // an outline around a notification badge
+)
+
+// overlay returns where a badge or a banner is drawn on a w x h icon, as overlayFill and overlayInk, and transparent elsewhere.
+// The badge is a circle in the lower right corner, with a dark or light edge, and the banner is a stripe along the bottom,
+// with the label of the environment.
+func (v variant) overlay(kind string, w, h int) *Pixels {
+	p := NewPixels(w, h, transparent)
+	s := w
+	if h < s {
+		s = h
+	}
+	switch kind {
+	case "badge":
+		d := (s*3 + 4) / 8
+		if d < 3 {
+			d = 3
+		}
+		in := func(x, y int) bool {
+			return x >= 0 && y >= 0 && x < d && y < d && inShape("circle", x, y, d, d)
+		}
+		for y := 0; y < d; y++ {
+			for x := 0; x < d; x++ {
+				if !in(x, y) {
+					continue
+				}
+				mark := overlayFill
+				if d >= 5 && (!in(x-1, y) || !in(x+1, y) || !in(x, y-1) || !in(x, y+1)) {
+					mark = overlayInk
+				}
+				p.Set(w-d+x, h-d+y, mark)
+			}
+		}
+	case "banner":
+		f := fitFont([]string{v.label}, 0, w-2, h/2)
+		t := f.Render([]string{v.label}, 0, 0)
+		bh := t.Height() + 2
+		for y := h - bh; y < h; y++ {
+			for x := 0; x < w; x++ {
+				p.Set(x, y, overlayFill)
+			}
+		}
+		area := image.Rect(0, h-bh, w, h)
+		o := textOptions{anchor: anchors["c"]}
+		p = stampText(p, t, o.position(area, t), overlayInk)
+	}
+	return p
+}
+
+// colorIntensity returns the intensity of the color, from 0 to 1
+func colorIntensity(c color.NRGBA) float64 {
+	return (0.2126*float64(c.R) + 0.7152*float64(c.G) + 0.0722*float64(c.B)) / 255
+}
+
+// inkColor returns black or white, whichever stands out the most from the given color
+func inkColor(c color.NRGBA) color.NRGBA {
+	if colorIntensity(c) >= 0.5 {
+		return color.NRGBA{0, 0, 0, 0xff}
+	}
+	return color.NRGBA{0xff, 0xff, 0xff, 0xff}
+}
+
+// drawOverlay draws the marks in the overlay onto the pixels, with the closest gray level to the color for each mark
+func drawOverlay(p, overlay *Pixels, colors map[byte]color.NRGBA) {
+	for i, mark := range overlay.values {
+		if c, ok := colors[mark]; ok {
+			p.values[i] = byte(math.Round(colorIntensity(c) * 15))
+		}
+	}
+}
+
+// drawOverlayImage draws the marks in the overlay onto the image, with the color for each mark
+func drawOverlayImage(m *image.NRGBA, overlay *Pixels, colors map[byte]color.NRGBA) {
+	for y := 0; y < overlay.h; y++ {
+		for x := 0; x < overlay.w; x++ {
+			if c, ok := colors[overlay.At(x, y)]; ok {
+				m.SetNRGBA(x, y, c)
+			}
+		}
+	}
+}
+
+// copyNRGBA returns a copy of the image in full color, starting at 0,0
+func copyNRGBA(m image.Image) *image.NRGBA {
+	b := m.Bounds()
+	m2 := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			m2.SetNRGBA(x, y, color.NRGBAModel.Convert(m.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA))
+		}
+	}
+	return m2
+}
+
+// ApplyPixels returns a copy of the pixels with the effects, in 16 levels of gray.
+// Desaturating with "gray" does nothing, since the pixels are already gray.
+func (v variant) ApplyPixels(p *Pixels) *Pixels {
+	p2 := p.Copy()
+	for _, eff := range v.effects {
+		switch eff.kind {
+		case "tint":
+			intensity := colorIntensity(eff.c)
+			p2 = p2.Adjust(func(x float64) float64 {
+				return x + (intensity-x)*float64(eff.percent)/100
+			})
+		case "badge", "banner":
+			drawOverlay(p2, v.overlay(eff.kind, p2.w, p2.h), map[byte]color.NRGBA{overlayFill: eff.c, overlayInk: inkColor(eff.c)})
+		}
+	}
+	return p2
+}
+
+// ApplyImage returns a copy of the image with the effects, in full color. The alpha channel is kept,
+// except where a badge or banner is drawn.
+func (v variant) ApplyImage(m image.Image) *image.NRGBA {
+	m2 := copyNRGBA(m)
+	for _, eff := range v.effects {
+		switch eff.kind {
+		case "tint":
+			mix := func(from, to uint8) uint8 {
+				return uint8(math.Round(float64(from) + (float64(to)-float64(from))*float64(eff.percent)/100))
+			}
+			for i := 0; i < len(m2.Pix); i += 4 {
+				m2.Pix[i], m2.Pix[i+1], m2.Pix[i+2] = mix(m2.Pix[i], eff.c.R), mix(m2.Pix[i+1], eff.c.G), mix(m2.Pix[i+2], eff.c.B)
+			}
+		case "gray":
+			for i := 0; i < len(m2.Pix); i += 4 {
+				c := color.NRGBA{m2.Pix[i], m2.Pix[i+1], m2.Pix[i+2], 0xff}
+				intensity := uint8(math.Round(colorIntensity(c) * 255))
+				m2.Pix[i], m2.Pix[i+1], m2.Pix[i+2] = intensity, intensity, intensity
+			}
+		case "badge", "banner":
+			overlay := v.overlay(eff.kind, m2.Bounds().Dx(), m2.Bounds().Dy())
+			drawOverlayImage(m2, overlay, map[byte]color.NRGBA{overlayFill: eff.c, overlayInk: inkColor(eff.c)})
+		}
+	}
+	return m2
+}
+
+// variantCommand writes variants of the image for the given environments, next to the image, like "variant staging badge".
+// While the command is being typed in, the first variant is previewed on the image.
+func variantCommand(e *Editor, args []string) (string, error) {
+	variants, err := parseVariants(args)
+	if err != nil {
+		return "", err
+	}
+	if e.previewLines != nil {
+		// Show the first variant of the image that is written, with all the visible layers
+		e.previewImage = variants[0].ApplyPixels(e.Composite())
+		return "", nil
+	}
+	if e.filename == "" {
+		return "", errors.New("the image has no filename")
+	}
+	var filenames []string
+	for _, v := range variants {
+		entries := []*Pixels{v.ApplyPixels(e.Composite())}
+		for _, entry := range e.entries {
+			entries = append(entries, v.ApplyPixels(entry))
+		}
+		filename := v.Filename(e.filename)
+		if err := WriteEntries(filename, entries); err != nil {
+			return "", err
+		}
+		filenames = append(filenames, filename)
+	}
+	return "Wrote " + strings.Join(filenames, ", "), nil
+}
+
+// variantSubcommand writes variants of an image file for the given environments, like "variant favicon.ico dev,staging banner".
+// PNG images get the effects in full color, while .ico and .fav files get them in 16 levels of gray, for every entry.
+func variantSubcommand(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", errors.New("usage: variant INPUT " + variantUsage)
+	}
+	input := args[0]
+	variants, err := parseVariants(args[1:])
+	if err != nil {
+		return "", err
+	}
+	var (
+		filenames []string
+		m         image.Image
+		entries   []*Pixels
+	)
+	if strings.HasSuffix(input, ".png") {
+		m, err = readImage(input)
+	} else {
+		entries, err = ReadEntries(input)
+	}
+	if err != nil {
+		return "", err
+	}
+	for _, v := range variants {
+		filename := v.Filename(input)
+		if m != nil {
+			err = writePNG(filename, v.ApplyImage(m))
+		} else {
+			changed := make([]*Pixels, len(entries))
+			for i, entry := range entries {
+				changed[i] = v.ApplyPixels(entry)
+			}
+			err = WriteEntries(filename, changed)
+		}
+		if err != nil {
+			return "", err
+		}
+		filenames = append(filenames, filename)
+	}
+	return fmt.Sprintf("Wrote %s", strings.Join(filenames, ", ")), nil
+}
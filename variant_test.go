@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVariantPreviewOfLayers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "favicon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	e := layeredEditor(t, false)
+	e.filename = filepath.Join(dir, "favicon.ico")
+	before := pixelsText(e.Pixels())
+
+	if !e.Preview("variant dev banner") {
+		t.Fatal("expected the variant to be previewed")
+	}
+	var rows []string
+	for _, row := range e.pixelRows() {
+		rows = append(rows, string(row))
+	}
+	preview := strings.Join(rows, "\n")
+	e.EndPreview()
+	if got := pixelsText(e.Pixels()); got != before {
+		t.Errorf("expected the active layer to be unchanged after the preview, got:\n%s", got)
+	}
+
+	if _, err := e.RunCommand("variant dev banner"); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ReadEntries(filepath.Join(dir, "favicon-dev.ico"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written := pixelsText(entries[0]); written != preview {
+		t.Errorf("expected the preview to be the written image:\n%s\ngot:\n%s", written, preview)
+	}
+}
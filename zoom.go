@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/xyproto/vt100"
+)
+
+// Zoom is the number of terminal cells that are used for each pixel, horizontally and vertically
+type Zoom struct {
+	w int
+	h int
+}
+
+// zoomLevels are the available zoom levels, from the smallest to the largest
+var zoomLevels = []Zoom{{1, 1}, {2, 1}, {4, 2}, {6, 3}}
+
+// defaultZoom is the index of the zoom level that is used when starting, which is two columns per pixel,
+// just like in the textual representation
+const defaultZoom = 1
+
+// String returns the zoom level as columns x rows, like "4x2"
+func (z Zoom) String() string {
+	return fmt.Sprintf("%dx%d", z.w, z.h)
+}
+
+// ParseZoom returns the index of the given zoom level, like "4x2"
+func ParseZoom(s string) (int, error) {
+	for i, z := range zoomLevels {
+		if z.String() == strings.ToLower(s) {
+			return i, nil
+		}
+	}
+	var names []string
+	for _, z := range zoomLevels {
+		names = append(names, z.String())
+	}
+	return 0, errors.New("the zoom level must be one of " + strings.Join(names, ", "))
+}
+
+// cellRune returns the rune that is drawn in the given column of the cells for one pixel.
+// The pixel rune fills the cells, but the last column is left blank as a gap between the pixels,
+// unless the pixels are only one column wide.
+func (z Zoom) cellRune(r rune, col int) rune {
+	if z.w > 1 && col == z.w-1 {
+		return ' '
+	}
+	return r
+}
+
+// zoom returns the current zoom level
+func (e *Editor) zoom() Zoom {
+	return zoomLevels[e.zoomLevel]
+}
+
+// gridWidth returns the width of the pixel grid on the screen, in columns
+func (e *Editor) gridWidth() int {
+	return e.width * e.zoom().w
+}
+
+// visibleRows returns the number of pixel rows that fit on the canvas, above the status bar
+func (e *Editor) visibleRows(c *vt100.Canvas) int {
+	rows := (int(c.H()) - 1) / e.zoom().h
+	if rows < 1 {
+		return 1
+	}
+	return rows
+}
+
+// ScrollToCursor will scroll the pixel grid so that the pixel at the cursor is visible.
+// Returns true if the grid was scrolled and needs to be redrawn.
+func (e *Editor) ScrollToCursor(c *vt100.Canvas) bool {
+	var (
+		y      = e.CursorPixel().Y
+		rows   = e.visibleRows(c)
+		scroll = e.pixelScroll
+	)
+	if y < scroll {
+		scroll = y
+	} else if y >= scroll+rows {
+		scroll = y - rows + 1
+	}
+	if scroll > e.height-rows {
+		scroll = e.height - rows
+	}
+	if scroll < 0 {
+		scroll = 0
+	}
+	changed := scroll != e.pixelScroll
+	e.pixelScroll = scroll
+	return changed
+}
+
+// CursorScreen returns the position of the cursor on the screen.
+// When drawing, this is the middle of the cells for the pixel at the cursor, at the current zoom level.
+func (e *Editor) CursorScreen() (int, int) {
+	if !e.drawMode {
+		return e.pos.ScreenX(), e.pos.ScreenY()
+	}
+	var (
+		z = e.zoom()
+		x = e.pos.sx * z.w / 2
+		y = (e.pos.sy - e.pixelScroll) * z.h
+	)
+	return x + (z.w-1)/2, y + (z.h-1)/2
+}
+
+// drawPixel will fill the cells for the pixel at x,y with the given rune and colors, at the current zoom level
+func (e *Editor) drawPixel(c *vt100.Canvas, cx, cy, x, y int, r rune, fg, bg vt100.AttributeColor) {
+	var (
+		z  = e.zoom()
+		w  = int(c.W())
+		h  = int(c.H()) - 1 // the last line is for the status bar
+		sx = cx + x*z.w
+		sy = cy + (y-e.pixelScroll)*z.h
+	)
+	for row := 0; row < z.h; row++ {
+		for col := 0; col < z.w; col++ {
+			if sx+col < 0 || sy+row < 0 || sx+col >= w || sy+row >= h {
+				continue
+			}
+			c.WriteRune(uint(sx+col), uint(sy+row), fg, bg, z.cellRune(r, col))
+		}
+	}
+}
+
+// pixelRows returns the rows of pixels that are shown, in the textual representation.
+// This is either the earlier state that is being compared with, the previewed image, all the visible
+// layers drawn on top of each other, or the pixels that are being edited.
+func (e *Editor) pixelRows() [][]rune {
+	rows := make([][]rune, e.height)
+	switch {
+	case e.comparing:
+		for y := range rows {
+			rows[y] = e.compareLines[y]
+		}
+	case e.previewImage != nil:
+		for y, line := range strings.Split(pixelsText(e.previewImage), "\n") {
+			rows[y] = []rune(line)
+		}
+	case e.layers.Len() > 1:
+		for y, line := range strings.Split(pixelsText(e.Composite()), "\n") {
+			rows[y] = []rune(line)
+		}
+	default:
+		for y := range rows {
+			rows[y] = e.lines[y]
+		}
+	}
+	return rows
+}
+
+// pixelRune returns the rune for the pixel at x,y in the given rows, or a blank
+func pixelRune(rows [][]rune, x, y int) rune {
+	if y < 0 || y >= len(rows) || x*2 >= len(rows[y]) {
+		return ' '
+	}
+	return rows[y][x*2]
+}
+
+// WritePixels will draw the pixel grid at the current zoom level, the legend and the other panels to the right of it,
+// and anything that is drawn on top of the pixels, like the selection
+func (e *Editor) WritePixels(c *vt100.Canvas, cx, cy int) {
+	var (
+		w    = int(c.W())
+		h    = int(c.H())
+		rows = e.pixelRows()
+	)
+	// Clear the canvas, except for the status bar
+	for y := cy; y < h-1; y++ {
+		for x := cx; x < w; x++ {
+			c.WriteRune(uint(x), uint(y), e.fg, e.bg, ' ')
+		}
+	}
+	for y := e.pixelScroll; y < e.height; y++ {
+		for x := 0; x < e.width; x++ {
+			r, bg := pixelRune(rows, x, y), e.bg
+			// Show the reference image behind transparent and empty pixels
+			if x*2 >= len(rows[y]) || r == 'T' {
+				if traceBg, ok := e.traceBackground(x, y); ok {
+					bg = traceBg
+				}
+			}
+			e.drawPixel(c, cx, cy, x, y, r, e.fg, bg)
+		}
+	}
+	// Draw the legend and the lists of layers and frames next to the pixel grid
+	e.drawLegend(c, 0, h, cx, cy)
+	e.drawLayers(c, 0, h, cx, cy)
+	e.drawFrames(c, 0, h, cx, cy)
+	if e.comparing {
+		// Only show the earlier state
+		return
+	}
+	// Show the previous frame where the current one is transparent
+	if e.onionSkin {
+		e.drawOnionSkin(c, cx, cy)
+	}
+	// Draw the symmetry axes, if any
+	if e.symmetry != symmetryOff {
+		e.drawSymmetryGuides(c, rows, cx, cy)
+	}
+	// Highlight the pixels that are searched for
+	if e.searching {
+		p := e.Pixels()
+		for y := e.pixelScroll; y < e.height; y++ {
+			for x := 0; x < e.width; x++ {
+				if p.At(x, y) == e.searchValue {
+					e.drawPixel(c, cx, cy, x, y, searchRune(pixelRune(rows, x, y)), e.searchFg, e.bg)
+				}
+			}
+		}
+	}
+	// Highlight the selected pixels
+	if sel, ok := e.Selection(); ok {
+		for y := sel.Min.Y; y < sel.Max.Y; y++ {
+			for x := sel.Min.X; x < sel.Max.X; x++ {
+				e.drawPixel(c, cx, cy, x, y, pixelRune(rows, x, y), e.fg, e.selectionBg)
+			}
+		}
+	}
+}
+
+// zoomCommand sets the zoom level, like "zoom 4x2", or zooms in or out
+func zoomCommand(e *Editor, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", errors.New("usage: zoom in|out|1x1|2x1|4x2|6x3")
+	}
+	switch strings.ToLower(args[0]) {
+	case "in", "+":
+		if e.zoomLevel == len(zoomLevels)-1 {
+			return "", errors.New("already at the largest zoom level")
+		}
+		e.zoomLevel++
+	case "out", "-":
+		if e.zoomLevel == 0 {
+			return "", errors.New("already at the smallest zoom level")
+		}
+		e.zoomLevel--
+	default:
+		level, err := ParseZoom(args[0])
+		if err != nil {
+			return "", err
+		}
+		e.zoomLevel = level
+	}
+	return "Zoom: " + e.zoom().String(), nil
+}